@@ -0,0 +1,20 @@
+package autorot
+
+import "math"
+
+// AngleDiff computes the absolute difference between two
+// angles, taking wrap-around into account.
+// The result is between 0 and pi.
+func AngleDiff(a1, a2 float64) float64 {
+	return math.Abs(math.Atan2(math.Sin(a1-a2), math.Cos(a1-a2)))
+}
+
+// NearestRightAngle returns the index (0 through 3) of the
+// multiple of 90 degrees closest to an angle.
+func NearestRightAngle(angle float64) int {
+	idx := int(math.Floor(angle/(math.Pi/2)+0.5)) % 4
+	if idx < 0 {
+		idx += 4
+	}
+	return idx
+}
@@ -0,0 +1,33 @@
+package autorot
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAngleDiff(t *testing.T) {
+	inputs := [][2]float64{
+		{0, 0.5},
+		{0.5, 0},
+		{0.1, 2*math.Pi - 0.1},
+		{-math.Pi / 2, 3 * math.Pi / 2},
+		{0, math.Pi},
+		{7 * math.Pi, 0.5},
+	}
+	expected := []float64{0.5, 0.5, 0.2, 0, math.Pi, math.Pi - 0.5}
+	for i, x := range inputs {
+		if a := AngleDiff(x[0], x[1]); math.Abs(a-expected[i]) > 1e-8 {
+			t.Errorf("angles %v: expected %f but got %f", x, expected[i], a)
+		}
+	}
+}
+
+func TestNearestRightAngle(t *testing.T) {
+	inputs := []float64{0, 0.7, 0.8, math.Pi, -0.1, -math.Pi / 2, 2*math.Pi - 0.1, 5 * math.Pi}
+	expected := []int{0, 0, 1, 2, 0, 3, 0, 2}
+	for i, x := range inputs {
+		if a := NearestRightAngle(x); a != expected[i] {
+			t.Errorf("angle %f: expected %d but got %d", x, expected[i], a)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package autorot
+
+import (
+	"image"
+	"math"
+)
+
+// Aggregation specifies how predictions for different
+// views of the same image are combined.
+type Aggregation int
+
+const (
+	// CircularMean averages the predicted angles as unit
+	// vectors, weighting each view by its confidence.
+	CircularMean Aggregation = iota
+
+	// LogProbSum adds up the log probabilities that each
+	// view assigns to each right angle.
+	// It only applies to RightAngles networks; other output
+	// types fall back to CircularMean.
+	LogProbSum
+)
+
+// TTA configures test-time augmentation.
+type TTA struct {
+	// Crops enables evaluation of the four corner crops and
+	// both the center crop and the full letterboxed image,
+	// whichever of the two the net does not use by default.
+	Crops bool
+
+	// Rotations enables evaluation of every view rotated by
+	// each right angle.
+	// The resulting predictions are un-rotated before they
+	// are aggregated.
+	Rotations bool
+
+	Aggregation Aggregation
+}
+
+// EvaluateTTA is like EvaluateBatch, but it evaluates a
+// number of augmented views of each image and combines
+// the results.
+//
+// With CircularMean, the confidence of an image is the
+// length of the confidence-weighted mean of its views'
+// unit vectors, so disagreement lowers the confidence.
+func (n *Net) EvaluateTTA(imgs []image.Image, t *TTA) (angles, confidences []float64) {
+	for _, img := range imgs {
+		views, turns := n.augmentedViews(img, t)
+		out := n.applyBatch(views)
+		var angle, confidence float64
+		if t.Aggregation == LogProbSum && n.OutputType == RightAngles {
+			angle, confidence = sumLogProbs(out.Data().([]float32), turns,
+				&n.Calibration)
+		} else {
+			viewAngles, viewConfs := n.decodeOutputs(out, len(views))
+			votes := make([]vote, len(views))
+			for i, turn := range turns {
+				votes[i] = vote{
+					Angle:         viewAngles[i] - float64(turn)*math.Pi/2,
+					Confidence:    viewConfs[i],
+					Weight:        1,
+					HasConfidence: n.OutputType != RawAngle,
+				}
+			}
+			angle, confidence = circularMean(votes)
+		}
+		angles = append(angles, angle)
+		confidences = append(confidences, confidence)
+	}
+	return
+}
+
+// augmentedViews produces the network inputs for an image
+// along with the number of clockwise quarter turns that
+// were applied to each of them.
+func (n *Net) augmentedViews(img image.Image, t *TTA) (views []image.Image, turns []int) {
+	views = []image.Image{fitInput(img, 0, n.InputSize, n.InputMode)}
+	if t.Crops {
+		bounds := img.Bounds()
+		side := bounds.Dx()
+		if bounds.Dy() < side {
+			side = bounds.Dy()
+		}
+		corners := []image.Point{
+			bounds.Min,
+			{X: bounds.Max.X - side, Y: bounds.Min.Y},
+			{X: bounds.Min.X, Y: bounds.Max.Y - side},
+			bounds.Max.Sub(image.Pt(side, side)),
+		}
+		for _, corner := range corners {
+			crop := &cropImage{
+				Image:  img,
+				bounds: image.Rectangle{Min: corner, Max: corner.Add(image.Pt(side, side))},
+			}
+			views = append(views, Rotate(crop, 0, n.InputSize))
+		}
+		if n.InputMode == Letterboxed {
+			views = append(views, Rotate(img, 0, n.InputSize))
+		} else {
+			views = append(views, Letterbox(img, n.InputSize))
+		}
+	}
+	turns = make([]int, len(views))
+	if t.Rotations {
+		numViews := len(views)
+		for turn := 1; turn < 4; turn++ {
+			for _, view := range views[:numViews] {
+				views = append(views, RotateRightAngle(view, turn))
+				turns = append(turns, turn)
+			}
+		}
+	}
+	return
+}
+
+// A vote is a single prediction to be combined with other
+// predictions by circularMean.
+type vote struct {
+	Angle      float64
+	Confidence float64
+	Weight     float64
+
+	// HasConfidence is false for predictions whose output
+	// type does not produce a confidence, in which case the
+	// confidence is not used to weight the angle.
+	HasConfidence bool
+}
+
+// circularMean averages angles as unit vectors.
+//
+// The resulting confidence is the length of the weighted
+// mean of the confidence-scaled unit vectors, so that any
+// disagreement lowers it.
+func circularMean(votes []vote) (angle, confidence float64) {
+	var x, y, confX, confY, totalWeight float64
+	for _, v := range votes {
+		weight := v.Weight
+		if v.HasConfidence {
+			weight *= v.Confidence
+		}
+		x += weight * math.Cos(v.Angle)
+		y += weight * math.Sin(v.Angle)
+		confX += v.Weight * v.Confidence * math.Cos(v.Angle)
+		confY += v.Weight * v.Confidence * math.Sin(v.Angle)
+		totalWeight += v.Weight
+	}
+	if x == 0 && y == 0 {
+		// Every vote had zero confidence.
+		for _, v := range votes {
+			x += v.Weight * math.Cos(v.Angle)
+			y += v.Weight * math.Sin(v.Angle)
+		}
+	}
+	angle = math.Atan2(y, x)
+	if angle < 0 {
+		angle += 2 * math.Pi
+	}
+	confidence = math.Hypot(confX, confY) / totalWeight
+	return
+}
+
+// sumLogProbs aggregates the outputs of a RightAngles net
+// for views rotated by the given numbers of quarter turns.
+func sumLogProbs(logProbs []float32, turns []int, c *Calibration) (angle, confidence float64) {
+	sums := make([]float32, 4)
+	for i, turn := range turns {
+		for j := 0; j < 4; j++ {
+			sums[(j-turn+4)%4] += logProbs[i*4+j]
+		}
+	}
+	probs := c.applyLogProbs(sums)
+	var maxIdx int
+	for i, x := range probs {
+		if x > probs[maxIdx] {
+			maxIdx = i
+		}
+	}
+	confidence = probs[maxIdx]
+	if c.Method != TemperatureScaling {
+		confidence = c.Apply(confidence)
+	}
+	return float64(maxIdx) * math.Pi / 2, confidence
+}
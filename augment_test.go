@@ -0,0 +1,52 @@
+package autorot
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestEvaluateTTA(t *testing.T) {
+	imgs := []image.Image{randomImage(6, 6), randomImage(6, 6)}
+	net := testNet(6, RightAngles)
+	angles, confidences := net.EvaluateBatch(imgs)
+	for _, aggregation := range []Aggregation{CircularMean, LogProbSum} {
+		ttaAngles, ttaConfs := net.EvaluateTTA(imgs, &TTA{Aggregation: aggregation})
+		for i, angle := range angles {
+			if math.Abs(angle-ttaAngles[i]) > 1e-4 {
+				t.Errorf("aggregation %d image %d: angle should be %f but got %f",
+					aggregation, i, angle, ttaAngles[i])
+			}
+			if math.Abs(confidences[i]-ttaConfs[i]) > 1e-4 {
+				t.Errorf("aggregation %d image %d: confidence should be %f but got %f",
+					aggregation, i, confidences[i], ttaConfs[i])
+			}
+		}
+	}
+
+	augmented := &TTA{Crops: true, Rotations: true}
+	ttaAngles, ttaConfs := net.EvaluateTTA([]image.Image{randomImage(10, 7)}, augmented)
+	if len(ttaAngles) != 1 || len(ttaConfs) != 1 {
+		t.Fatal("unexpected output count")
+	}
+	if ttaConfs[0] < 0 || ttaConfs[0] > 1 {
+		t.Errorf("confidence out of range: %f", ttaConfs[0])
+	}
+}
+
+func TestSumLogProbs(t *testing.T) {
+	logProbs := []float32{
+		// Unrotated view favoring 90 degrees.
+		-2.30259, -0.22314, -2.30259, -2.30259,
+		// View rotated by 90 degrees favoring 180 degrees.
+		-2.30259, -2.30259, -0.22314, -2.30259,
+	}
+	angle, confidence := sumLogProbs(logProbs, []int{0, 1}, &Calibration{})
+	if math.Abs(angle-math.Pi/2) > 1e-5 {
+		t.Errorf("expected angle %f but got %f", math.Pi/2, angle)
+	}
+	expectedConf := 0.64 / (0.64 + 3*0.01)
+	if math.Abs(confidence-expectedConf) > 1e-3 {
+		t.Errorf("expected confidence %f but got %f", expectedConf, confidence)
+	}
+}
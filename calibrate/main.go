@@ -0,0 +1,209 @@
+// Command calibrate fits a confidence calibration for an
+// autorot network and stores it in the network.
+//
+// The network is evaluated on a directory of upright
+// images, each of which is rotated by all four right
+// angles.
+// The calibration is fit on part of the images and
+// evaluated on the rest, since a fit is always well
+// calibrated on its own data.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	var netPath string
+	var outPath string
+	var dataDir string
+	var method string
+	var numBins int
+	var precision float64
+	var batchSize int
+	var holdout float64
+	var seed int64
+
+	flag.StringVar(&netPath, "net", "", "network path")
+	flag.StringVar(&outPath, "out", "", "output network path (defaults to -net)")
+	flag.StringVar(&dataDir, "data", "", "directory of upright images")
+	flag.StringVar(&method, "method", "temperature", "calibration method (temperature or isotonic)")
+	flag.IntVar(&numBins, "bins", 10, "number of reliability diagram bins")
+	flag.Float64Var(&precision, "precision", 0.95, "target precision for the threshold")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size")
+	flag.Float64Var(&holdout, "holdout", 0.2,
+		"fraction of images held out to evaluate the calibration")
+	flag.Int64Var(&seed, "seed", 0, "seed for choosing the held-out images")
+	flag.Parse()
+
+	if netPath == "" || dataDir == "" {
+		essentials.Die("Required flags: -net and -data. See -help for more.")
+	}
+	if holdout <= 0 || holdout >= 1 {
+		essentials.Die("The -holdout flag must be between 0 and 1.")
+	}
+	if outPath == "" {
+		outPath = netPath
+	}
+
+	var net *autorot.Net
+	if err := serializer.LoadAny(netPath, &net); err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	if net.OutputType == autorot.RawAngle {
+		essentials.Die("RawAngle networks do not produce confidences.")
+	}
+
+	listing, err := autorot.ReadSampleList(net.InputSize, dataDir)
+	if err != nil {
+		essentials.Die("Load data failed:", err)
+	}
+
+	// Views of the same image are kept in the same split.
+	paths := make([]string, len(listing.Paths))
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(len(paths)) {
+		paths[i] = listing.Paths[j]
+	}
+	numHeldOut := int(float64(len(paths))*holdout + 0.5)
+	if numHeldOut < 1 || numHeldOut >= len(paths) {
+		essentials.Die("Not enough images to hold out a fraction of", holdout)
+	}
+	fitPaths, heldOutPaths := paths[numHeldOut:], paths[:numHeldOut]
+
+	log.Printf("Evaluating %d images (%d held out)...", len(paths), numHeldOut)
+	uncalibrated := *net
+	uncalibrated.Calibration = autorot.Calibration{}
+	samples := evaluatePaths(&uncalibrated, fitPaths, batchSize)
+	heldOut := evaluatePaths(&uncalibrated, heldOutPaths, batchSize)
+	if len(samples) == 0 || len(heldOut) == 0 {
+		essentials.Die("No images could be evaluated.")
+	}
+
+	switch method {
+	case "temperature":
+		net.Calibration = autorot.Calibration{
+			Method:      autorot.TemperatureScaling,
+			Temperature: autorot.FitTemperature(samples),
+		}
+		fmt.Printf("Fit temperature: %f\n", net.Calibration.Temperature)
+	case "isotonic":
+		xs, ys := autorot.FitIsotonic(samples)
+		net.Calibration = autorot.Calibration{
+			Method:    autorot.IsotonicCalibration,
+			IsotonicX: xs,
+			IsotonicY: ys,
+		}
+		fmt.Printf("Fit isotonic mapping with %d knots\n", len(xs))
+	default:
+		essentials.Die("Unknown calibration method:", method)
+	}
+
+	calibrated := make([]autorot.CalibrationSample, len(heldOut))
+	for i, s := range heldOut {
+		calibrated[i] = net.Calibration.CalibrateSample(s)
+	}
+
+	fmt.Println()
+	fmt.Println("Before calibration (held out):")
+	printDiagram(autorot.ReliabilityDiagram(heldOut, numBins))
+	fmt.Println()
+	fmt.Println("After calibration (held out):")
+	printDiagram(autorot.ReliabilityDiagram(calibrated, numBins))
+
+	fmt.Println()
+	threshold, coverage, err := autorot.ThresholdForPrecision(calibrated, precision)
+	if err != nil {
+		fmt.Printf("Precision %.3f: %v\n", precision, err)
+	} else {
+		fmt.Printf("Precision %.3f: threshold=%f coverage=%.2f%%\n", precision, threshold,
+			coverage*100)
+	}
+
+	log.Println("Saving network...")
+	if err := serializer.SaveAny(outPath, net); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+}
+
+func evaluatePaths(net *autorot.Net, paths []string,
+	batchSize int) []autorot.CalibrationSample {
+	var res []autorot.CalibrationSample
+	for i := 0; i < len(paths); i += batchSize {
+		end := i + batchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		res = append(res, evaluateBatch(net, paths[i:end])...)
+	}
+	return res
+}
+
+func evaluateBatch(net *autorot.Net, paths []string) []autorot.CalibrationSample {
+	var views []image.Image
+	var labels []int
+	for _, path := range paths {
+		img, _, err := autorot.ReadImage(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		for turns := 0; turns < 4; turns++ {
+			views = append(views, autorot.RotateRightAngle(img, turns))
+			labels = append(labels, turns)
+		}
+	}
+	if len(views) == 0 {
+		return nil
+	}
+
+	samples := make([]autorot.CalibrationSample, len(views))
+	if net.OutputType == autorot.RightAngles {
+		for i, probs := range net.RightAngleProbs(views) {
+			var maxIdx int
+			for j, p := range probs {
+				if p > probs[maxIdx] {
+					maxIdx = j
+				}
+			}
+			samples[i] = autorot.CalibrationSample{
+				Confidence: probs[maxIdx],
+				Correct:    maxIdx == labels[i],
+				Probs:      append([]float64{}, probs[:]...),
+				Label:      labels[i],
+			}
+		}
+	} else {
+		angles, confidences := net.EvaluateBatch(views)
+		for i, angle := range angles {
+			samples[i] = autorot.CalibrationSample{
+				Confidence: confidences[i],
+				Correct:    autorot.NearestRightAngle(angle) == labels[i],
+				Label:      labels[i],
+			}
+		}
+	}
+	return samples
+}
+
+func printDiagram(bins []autorot.ReliabilityBin) {
+	fmt.Println("  range        count   confidence  accuracy")
+	for _, b := range bins {
+		if b.Count == 0 {
+			continue
+		}
+		fmt.Printf("  [%.2f,%.2f)  %-7d %-11.4f %.4f\n", b.Min, b.Max, b.Count,
+			b.MeanConfidence, b.Accuracy)
+	}
+	fmt.Printf("  ECE: %.4f\n", autorot.ExpectedCalibrationError(bins))
+}
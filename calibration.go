@@ -0,0 +1,341 @@
+package autorot
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/unixpickle/serializer"
+)
+
+// CalibrationMethod specifies how raw confidences are
+// mapped to calibrated confidences.
+type CalibrationMethod int
+
+const (
+	NoCalibration CalibrationMethod = iota
+
+	// TemperatureScaling divides the log probabilities of a
+	// RightAngles net by a temperature before the softmax.
+	// For other output types, the temperature divides the
+	// logit of the confidence.
+	TemperatureScaling
+
+	// IsotonicCalibration maps confidences through a
+	// monotonic piecewise-linear function.
+	IsotonicCalibration
+)
+
+// Calibration maps the raw confidences of a Net to
+// calibrated confidences, i.e. confidences that match the
+// observed probability of a correct prediction.
+type Calibration struct {
+	Method CalibrationMethod
+
+	// Temperature is used by TemperatureScaling.
+	Temperature float64
+
+	// IsotonicX and IsotonicY are the knots of the function
+	// used by IsotonicCalibration.
+	// IsotonicX is sorted in ascending order.
+	IsotonicX []float64
+	IsotonicY []float64
+}
+
+// Apply calibrates a confidence.
+//
+// For RightAngles nets with TemperatureScaling, the full
+// probability distribution should be calibrated instead.
+func (c *Calibration) Apply(confidence float64) float64 {
+	switch c.Method {
+	case TemperatureScaling:
+		return sigmoid(logit(confidence) / c.Temperature)
+	case IsotonicCalibration:
+		return interpolateKnots(c.IsotonicX, c.IsotonicY, confidence)
+	default:
+		return confidence
+	}
+}
+
+// applyLogProbs computes calibrated right angle
+// probabilities from log probabilities.
+// Only TemperatureScaling affects the distribution; other
+// methods calibrate the maximum probability via Apply.
+func (c *Calibration) applyLogProbs(logProbs []float32) [4]float64 {
+	temp := 1.0
+	if c.Method == TemperatureScaling {
+		temp = c.Temperature
+	}
+	var res [4]float64
+	maxVal := math.Inf(-1)
+	for _, x := range logProbs {
+		maxVal = math.Max(maxVal, float64(x)/temp)
+	}
+	var sum float64
+	for i, x := range logProbs {
+		res[i] = math.Exp(float64(x)/temp - maxVal)
+		sum += res[i]
+	}
+	for i := range res {
+		res[i] /= sum
+	}
+	return res
+}
+
+// CalibrateSample applies the calibration to the
+// confidence and probabilities of a sample.
+func (c *Calibration) CalibrateSample(s CalibrationSample) CalibrationSample {
+	if s.Probs == nil {
+		s.Confidence = c.Apply(s.Confidence)
+		return s
+	}
+	logProbs := make([]float32, len(s.Probs))
+	var maxIdx int
+	for i, p := range s.Probs {
+		logProbs[i] = float32(math.Log(math.Max(p, 1e-30)))
+		if p > s.Probs[maxIdx] {
+			maxIdx = i
+		}
+	}
+	probs := c.applyLogProbs(logProbs)
+	s.Probs = probs[:]
+	s.Confidence = probs[maxIdx]
+	if c.Method != TemperatureScaling {
+		s.Confidence = c.Apply(s.Confidence)
+	}
+	return s
+}
+
+func deserializeCalibration(d []byte) (Calibration, error) {
+	var res Calibration
+	err := serializer.DeserializeAny(d, &res.Method, &res.Temperature, &res.IsotonicX,
+		&res.IsotonicY)
+	return res, err
+}
+
+func (c *Calibration) serialize() ([]byte, error) {
+	return serializer.SerializeAny(
+		serializer.Int(c.Method),
+		serializer.Float64(c.Temperature),
+		serializer.Float64Slice(c.IsotonicX),
+		serializer.Float64Slice(c.IsotonicY),
+	)
+}
+
+// A CalibrationSample is a prediction with a known answer.
+type CalibrationSample struct {
+	// Confidence is the uncalibrated confidence.
+	Confidence float64
+
+	// Correct indicates if the prediction was right.
+	Correct bool
+
+	// Probs is the uncalibrated right angle distribution
+	// of a RightAngles net, or nil for other output types.
+	Probs []float64
+
+	// Label is the index of the correct right angle.
+	// It is only used along with Probs.
+	Label int
+}
+
+// FitTemperature finds the temperature that minimizes the
+// negative log-likelihood of the samples.
+//
+// If the samples have probability distributions, the
+// likelihood of the label is used.
+// Otherwise, the likelihood of the correctness is used.
+func FitTemperature(samples []CalibrationSample) float64 {
+	nll := func(temp float64) float64 {
+		var res float64
+		for _, s := range samples {
+			if s.Probs != nil {
+				logProbs := make([]float32, len(s.Probs))
+				for i, p := range s.Probs {
+					logProbs[i] = float32(math.Log(math.Max(p, 1e-30)))
+				}
+				c := Calibration{Method: TemperatureScaling, Temperature: temp}
+				res -= math.Log(math.Max(c.applyLogProbs(logProbs)[s.Label], 1e-30))
+			} else {
+				p := sigmoid(logit(s.Confidence) / temp)
+				if !s.Correct {
+					p = 1 - p
+				}
+				res -= math.Log(math.Max(p, 1e-30))
+			}
+		}
+		return res
+	}
+
+	// Golden-section search over the log temperature.
+	lo, hi := math.Log(0.05), math.Log(20.0)
+	ratio := (math.Sqrt(5) - 1) / 2
+	for i := 0; i < 60; i++ {
+		m1 := hi - ratio*(hi-lo)
+		m2 := lo + ratio*(hi-lo)
+		if nll(math.Exp(m1)) < nll(math.Exp(m2)) {
+			hi = m2
+		} else {
+			lo = m1
+		}
+	}
+	return math.Exp((lo + hi) / 2)
+}
+
+// FitIsotonic fits a monotonic mapping from confidences to
+// the probability of a correct prediction using the pool
+// adjacent violators algorithm.
+//
+// The result can be used for IsotonicX and IsotonicY.
+func FitIsotonic(samples []CalibrationSample) (xs, ys []float64) {
+	sorted := append([]CalibrationSample{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Confidence < sorted[j].Confidence
+	})
+
+	type block struct {
+		sumX, sumY, count float64
+	}
+	var blocks []block
+	for _, s := range sorted {
+		b := block{sumX: s.Confidence, count: 1}
+		if s.Correct {
+			b.sumY = 1
+		}
+		blocks = append(blocks, b)
+		for len(blocks) > 1 {
+			last := blocks[len(blocks)-1]
+			prev := blocks[len(blocks)-2]
+			if prev.sumY/prev.count < last.sumY/last.count &&
+				prev.sumX/prev.count < last.sumX/last.count {
+				break
+			}
+			blocks = blocks[:len(blocks)-1]
+			blocks[len(blocks)-1] = block{
+				sumX:  prev.sumX + last.sumX,
+				sumY:  prev.sumY + last.sumY,
+				count: prev.count + last.count,
+			}
+		}
+	}
+
+	for _, b := range blocks {
+		xs = append(xs, b.sumX/b.count)
+		ys = append(ys, b.sumY/b.count)
+	}
+	return
+}
+
+// A ReliabilityBin summarizes the samples whose confidence
+// falls in a range.
+type ReliabilityBin struct {
+	Min, Max float64
+
+	Count          int
+	MeanConfidence float64
+	Accuracy       float64
+}
+
+// ReliabilityDiagram groups samples into equally sized
+// confidence ranges.
+func ReliabilityDiagram(samples []CalibrationSample, numBins int) []ReliabilityBin {
+	bins := make([]ReliabilityBin, numBins)
+	for i := range bins {
+		bins[i].Min = float64(i) / float64(numBins)
+		bins[i].Max = float64(i+1) / float64(numBins)
+	}
+	for _, s := range samples {
+		idx := int(s.Confidence * float64(numBins))
+		if idx >= numBins {
+			idx = numBins - 1
+		} else if idx < 0 {
+			idx = 0
+		}
+		bins[idx].Count++
+		bins[idx].MeanConfidence += s.Confidence
+		if s.Correct {
+			bins[idx].Accuracy++
+		}
+	}
+	for i := range bins {
+		if bins[i].Count > 0 {
+			bins[i].MeanConfidence /= float64(bins[i].Count)
+			bins[i].Accuracy /= float64(bins[i].Count)
+		}
+	}
+	return bins
+}
+
+// ExpectedCalibrationError computes the mean absolute
+// difference between confidence and accuracy across the
+// bins of a reliability diagram, weighted by bin size.
+func ExpectedCalibrationError(bins []ReliabilityBin) float64 {
+	var total int
+	var sum float64
+	for _, b := range bins {
+		total += b.Count
+		sum += float64(b.Count) * math.Abs(b.MeanConfidence-b.Accuracy)
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / float64(total)
+}
+
+// ThresholdForPrecision finds the lowest confidence
+// threshold such that the predictions with at least that
+// confidence are correct with the target precision.
+//
+// It returns the threshold and the fraction of samples
+// which meet the threshold.
+// An error is returned if no threshold works.
+func ThresholdForPrecision(samples []CalibrationSample,
+	precision float64) (threshold, coverage float64, err error) {
+	sorted := append([]CalibrationSample{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Confidence > sorted[j].Confidence
+	})
+	var numCorrect int
+	found := false
+	for i, s := range sorted {
+		if s.Correct {
+			numCorrect++
+		}
+		if i+1 < len(sorted) && sorted[i+1].Confidence == s.Confidence {
+			// Thresholds cannot split equal confidences.
+			continue
+		}
+		if float64(numCorrect)/float64(i+1) >= precision {
+			threshold = s.Confidence
+			coverage = float64(i+1) / float64(len(sorted))
+			found = true
+		}
+	}
+	if !found {
+		return 0, 0, errors.New("no threshold achieves the target precision")
+	}
+	return
+}
+
+func interpolateKnots(xs, ys []float64, x float64) float64 {
+	if len(xs) == 0 {
+		return x
+	}
+	idx := sort.SearchFloat64s(xs, x)
+	if idx == 0 {
+		return ys[0]
+	} else if idx == len(xs) {
+		return ys[len(ys)-1]
+	}
+	frac := (x - xs[idx-1]) / (xs[idx] - xs[idx-1])
+	return ys[idx-1] + frac*(ys[idx]-ys[idx-1])
+}
+
+func logit(p float64) float64 {
+	p = math.Max(1e-6, math.Min(1-1e-6, p))
+	return math.Log(p / (1 - p))
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
@@ -0,0 +1,87 @@
+package autorot
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitTemperature(t *testing.T) {
+	var samples []CalibrationSample
+	for i := 0; i < 100; i++ {
+		samples = append(samples, CalibrationSample{Confidence: 0.9, Correct: i < 75})
+	}
+	temp := FitTemperature(samples)
+	if math.Abs(temp-2) > 1e-3 {
+		t.Errorf("expected temperature 2 but got %f", temp)
+	}
+	c := Calibration{Method: TemperatureScaling, Temperature: temp}
+	if conf := c.Apply(0.9); math.Abs(conf-0.75) > 1e-3 {
+		t.Errorf("expected calibrated confidence 0.75 but got %f", conf)
+	}
+}
+
+func TestFitIsotonic(t *testing.T) {
+	samples := []CalibrationSample{
+		{Confidence: 0.3, Correct: false},
+		{Confidence: 0.1, Correct: false},
+		{Confidence: 0.9, Correct: true},
+		{Confidence: 0.2, Correct: true},
+	}
+	xs, ys := FitIsotonic(samples)
+	expectedXs := []float64{0.1, 0.25, 0.9}
+	expectedYs := []float64{0, 0.5, 1}
+	if len(xs) != len(expectedXs) || len(ys) != len(expectedYs) {
+		t.Fatalf("expected knots %v, %v but got %v, %v", expectedXs, expectedYs, xs, ys)
+	}
+	for i, x := range expectedXs {
+		if math.Abs(x-xs[i]) > 1e-8 || math.Abs(expectedYs[i]-ys[i]) > 1e-8 {
+			t.Errorf("knot %d: expected (%f, %f) but got (%f, %f)", i, x,
+				expectedYs[i], xs[i], ys[i])
+		}
+	}
+	c := Calibration{Method: IsotonicCalibration, IsotonicX: xs, IsotonicY: ys}
+	inputs := []float64{0, 0.1, 0.175, 0.575, 1}
+	expected := []float64{0, 0, 0.25, 0.75, 1}
+	for i, x := range inputs {
+		if a := c.Apply(x); math.Abs(a-expected[i]) > 1e-8 {
+			t.Errorf("input %f: expected %f but got %f", x, expected[i], a)
+		}
+	}
+}
+
+func TestExpectedCalibrationError(t *testing.T) {
+	samples := []CalibrationSample{
+		{Confidence: 0.95, Correct: true},
+		{Confidence: 0.95, Correct: false},
+		{Confidence: 0.15, Correct: false},
+		{Confidence: 0.05, Correct: false},
+	}
+	bins := ReliabilityDiagram(samples, 10)
+	if bins[9].Count != 2 || bins[9].Accuracy != 0.5 || bins[0].Count != 1 {
+		t.Errorf("unexpected bins: %v", bins)
+	}
+	expected := (2*0.45 + 0.15 + 0.05) / 4
+	if ece := ExpectedCalibrationError(bins); math.Abs(ece-expected) > 1e-8 {
+		t.Errorf("expected ECE %f but got %f", expected, ece)
+	}
+}
+
+func TestThresholdForPrecision(t *testing.T) {
+	samples := []CalibrationSample{
+		{Confidence: 0.5, Correct: false},
+		{Confidence: 0.9, Correct: true},
+		{Confidence: 0.7, Correct: false},
+		{Confidence: 0.8, Correct: true},
+		{Confidence: 0.6, Correct: true},
+	}
+	threshold, coverage, err := ThresholdForPrecision(samples, 0.75)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != 0.6 || coverage != 0.8 {
+		t.Errorf("expected (0.6, 0.8) but got (%f, %f)", threshold, coverage)
+	}
+	if _, _, err := ThresholdForPrecision(samples[:1], 0.5); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unixpickle/autorot"
+)
+
+// A Corrector writes corrected copies of images into a
+// directory tree that mirrors the input directory.
+type Corrector struct {
+	InDir  string
+	OutDir string
+
+	// Threshold is the minimum confidence for an image to
+	// be corrected.
+	Threshold float64
+
+	// Snap indicates that predictions should be snapped to
+	// right angles.
+	Snap bool
+
+	// Encoding is "same", "jpeg", or "png".
+	Encoding string
+	Quality  int
+}
+
+// Correct writes a corrected copy of an image, if the
+// prediction is confident and calls for a rotation.
+// It reports whether or not a copy was written.
+func (c *Corrector) Correct(path string, img image.Image, format string, angle,
+	confidence float64) (bool, error) {
+	if confidence < c.Threshold {
+		return false, nil
+	}
+	if c.Snap && autorot.CorrectionTurns(angle) == 0 ||
+		!c.Snap && autorot.AngleDiff(angle, 0) < 1e-4 {
+		return false, nil
+	}
+
+	encoding := c.Encoding
+	if encoding == "same" {
+		encoding = format
+	}
+	var exif []byte
+	if format == "jpeg" && encoding == "jpeg" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, errors.New("correct image: " + err.Error())
+		}
+		exif, _ = autorot.JPEGExif(data)
+	}
+
+	outPath, err := c.outputPath(path, format, encoding)
+	if err != nil {
+		return false, errors.New("correct image: " + err.Error())
+	}
+	var buf bytes.Buffer
+	corrected := autorot.Correct(img, angle, c.Snap)
+	if err := autorot.EncodeImage(&buf, corrected, encoding, c.Quality, exif); err != nil {
+		return false, errors.New("correct image " + path + ": " + err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return false, errors.New("correct image: " + err.Error())
+	}
+	if err := ioutil.WriteFile(outPath, buf.Bytes(), 0644); err != nil {
+		return false, errors.New("correct image: " + err.Error())
+	}
+	return true, nil
+}
+
+func (c *Corrector) outputPath(path, format, encoding string) (string, error) {
+	rel, err := filepath.Rel(c.InDir, path)
+	if err != nil {
+		return "", err
+	}
+	outPath := filepath.Join(c.OutDir, rel)
+	if encoding != format {
+		ext := map[string]string{"jpeg": ".jpg", "png": ".png"}[encoding]
+		outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) + ext
+	}
+	return outPath, nil
+}
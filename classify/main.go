@@ -1,68 +1,354 @@
 package main
 
 import (
-	"encoding/csv"
-	"errors"
 	"flag"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/unixpickle/autorot"
 	"github.com/unixpickle/essentials"
-	"github.com/unixpickle/serializer"
 )
 
 func main() {
 	var dirPath string
 	var netPath string
+	var batchSize int
+	var tta autorot.TTA
+	var aggregation string
+	var corrector Corrector
+	var workers int
+	var unordered bool
+	var outputPath string
+	var resume bool
+	var verify string
+	var cpuPlan bool
+	var format string
 	flag.StringVar(&dirPath, "dir", "", "image directory")
-	flag.StringVar(&netPath, "net", "", "network path")
+	flag.StringVar(&netPath, "net", "", "network or ensemble path")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size")
+	flag.IntVar(&workers, "workers", runtime.GOMAXPROCS(0),
+		"number of batches to decode and evaluate concurrently")
+	flag.BoolVar(&unordered, "unordered", false,
+		"write results as soon as they are ready instead of in listing order")
+	flag.BoolVar(&tta.Crops, "tta-crops", false, "also evaluate corner crops and the letterboxed image")
+	flag.BoolVar(&tta.Rotations, "tta-rotations", false, "also evaluate the image rotated by right angles")
+	flag.StringVar(&aggregation, "aggregate", "circular",
+		"TTA aggregation method (circular or logprob)")
+	flag.StringVar(&outputPath, "output", "", "output path (default: standard output)")
+	flag.StringVar(&format, "format", autorot.CSVRecords, "output format (csv, jsonl, or tsv)")
+	flag.BoolVar(&resume, "resume", false, "skip images already listed in the output file")
+	flag.StringVar(&verify, "verify", VerifyStat,
+		"how to check that resumed images and the model are unchanged (none, stat, or hash)")
+	flag.StringVar(&corrector.OutDir, "out", "",
+		"directory for corrected copies of confident, rotated images")
+	flag.Float64Var(&corrector.Threshold, "threshold", 0.5, "minimum confidence for corrections")
+	flag.BoolVar(&corrector.Snap, "snap", true,
+		"snap corrections to right angles (otherwise rotate the full frame)")
+	flag.StringVar(&corrector.Encoding, "encoding", "same",
+		"encoding for corrected images (same, jpeg, or png)")
+	flag.IntVar(&corrector.Quality, "quality", 95, "JPEG quality for corrected images")
+	flag.BoolVar(&cpuPlan, "cpu-plan", false, "evaluate nets with compiled CPU inference plans")
 	flag.Parse()
 	if dirPath == "" || netPath == "" {
 		essentials.Die("Required flags: -net and -dir. See -help for more.")
 	}
+	if batchSize < 1 {
+		essentials.Die("Batch size must be at least 1.")
+	}
+	switch corrector.Encoding {
+	case "same", "jpeg", "png":
+	default:
+		essentials.Die("Unknown encoding:", corrector.Encoding)
+	}
+	switch format {
+	case autorot.CSVRecords, autorot.JSONLRecords, autorot.TSVRecords:
+	default:
+		essentials.Die("Unknown format:", format)
+	}
+	switch verify {
+	case VerifyNone, VerifyStat, VerifyHash:
+	default:
+		essentials.Die("Unknown verification mode:", verify)
+	}
+	if resume && outputPath == "" {
+		essentials.Die("The -resume flag requires -output.")
+	}
+	corrector.InDir = dirPath
+	var correct *Corrector
+	if corrector.OutDir != "" {
+		correct = &corrector
+	}
+
+	switch aggregation {
+	case "circular":
+		tta.Aggregation = autorot.CircularMean
+	case "logprob":
+		tta.Aggregation = autorot.LogProbSum
+	default:
+		essentials.Die("Unknown aggregation method:", aggregation)
+	}
 
-	var net *autorot.Net
-	if err := serializer.LoadAny(netPath, &net); err != nil {
+	model, err := autorot.LoadEvaluator(netPath)
+	if err != nil {
 		essentials.Die("Load network failed:", err)
 	}
+	if cpuPlan {
+		if err := autorot.CompileEvaluator(model); err != nil {
+			essentials.Die("Compile plan failed:", err)
+		}
+	}
+	if correct != nil && corrector.Threshold > 0 && !autorot.HasConfidence(model) {
+		essentials.Die("The network does not produce confidences (RawAngle outputs), " +
+			"so -threshold must be 0.")
+	}
+	modelID, err := autorot.HashFile(netPath)
+	if err != nil {
+		essentials.Die("Hash network failed:", err)
+	}
 
-	outWriter := csv.NewWriter(os.Stdout)
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+	output := os.Stdout
+	needsHeader := true
+	var resumer *Resume
+	if resume {
+		resumer = &Resume{Verify: verify, Format: format, Model: modelID}
+	}
+	if outputPath != "" {
+		output, needsHeader, err = OpenOutput(outputPath, resumer)
 		if err != nil {
-			return err
+			essentials.Die("Open output failed:", err)
 		}
-		ext := strings.ToLower(filepath.Ext(info.Name()))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
-			if err := processImage(outWriter, net, path); err != nil {
-				fmt.Fprintln(os.Stderr, err)
+		defer output.Close()
+		if resumer != nil {
+			log.Printf("Resuming after %d classified images.", resumer.Len())
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	batches := make(chan *batch, workers)
+	results := make(chan *batch, workers)
+
+	// Limit the batches between listing and writing, so
+	// that one slow batch cannot make the writer buffer an
+	// unbounded number of later batches.
+	inFlight := make(chan struct{}, 4*workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workerModel := model
+		if i > 0 {
+			workerModel, err = autorot.CloneEvaluator(model)
+			if err != nil {
+				essentials.Die("Clone network failed:", err)
 			}
 		}
-		return nil
-	})
+		p := &processor{
+			Evaluate:  evalFuncFor(workerModel, &tta),
+			Model:     modelID,
+			Corrector: correct,
+			Resume:    resumer,
+			Hash:      verify == VerifyHash,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				p.Process(b)
+				results <- b
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		var paths []string
+		var infos []os.FileInfo
+		var index int
+		err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && correct != nil && filepath.Clean(path) == filepath.Clean(correct.OutDir) {
+				// Do not classify our own output.
+				return filepath.SkipDir
+			}
+			ext := strings.ToLower(filepath.Ext(info.Name()))
+			if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
+				paths = append(paths, path)
+				infos = append(infos, info)
+				if len(paths) == batchSize {
+					inFlight <- struct{}{}
+					batches <- &batch{Index: index, Paths: paths, Infos: infos}
+					index++
+					paths, infos = nil, nil
+				}
+			}
+			return nil
+		})
+		if len(paths) > 0 {
+			inFlight <- struct{}{}
+			batches <- &batch{Index: index, Paths: paths, Infos: infos}
+		}
+		walkErr <- err
+	}()
+
+	writer, err := autorot.NewRecordWriter(output, format)
 	if err != nil {
+		essentials.Die(err)
+	}
+	if needsHeader {
+		if err := writer.WriteHeader(); err != nil {
+			essentials.Die("Write output failed:", err)
+		}
+	}
+	writeResults(writer, results, inFlight, unordered)
+
+	if err := <-walkErr; err != nil {
 		essentials.Die("Directory listing failed:", err)
 	}
 }
 
-func processImage(w *csv.Writer, network *autorot.Net, imgPath string) error {
-	f, err := os.Open(imgPath)
-	if err != nil {
-		return errors.New("process image: " + err.Error())
+// A batch is a group of consecutive image paths, numbered
+// in the order they were listed.
+type batch struct {
+	Index   int
+	Paths   []string
+	Infos   []os.FileInfo
+	Records []*autorot.Record
+}
+
+// An evalFunc predicts angles and confidences for images.
+// The probs result may be nil if they are unavailable.
+type evalFunc func(imgs []image.Image) (angles, confidences []float64,
+	probs [][4]float64)
+
+// A probEvaluator can produce right angle probabilities
+// along with its predictions.
+// Both *autorot.Net and *autorot.Ensemble implement it.
+type probEvaluator interface {
+	EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+		probs [][4]float64)
+}
+
+func evalFuncFor(model autorot.Evaluator, tta *autorot.TTA) evalFunc {
+	if tta.Crops || tta.Rotations {
+		return func(imgs []image.Image) ([]float64, []float64, [][4]float64) {
+			angles, confidences := model.EvaluateTTA(imgs, tta)
+			return angles, confidences, nil
+		}
 	}
-	defer f.Close()
-	img, _, err := image.Decode(f)
-	if err != nil {
-		return errors.New("process image " + imgPath + ": " + err.Error())
+	if p, ok := model.(probEvaluator); ok {
+		return p.EvaluateProbs
+	}
+	return func(imgs []image.Image) ([]float64, []float64, [][4]float64) {
+		angles, confidences := model.EvaluateBatch(imgs)
+		return angles, confidences, nil
+	}
+}
+
+// writeResults writes the records of processed batches,
+// receiving from inFlight once per batch written.
+//
+// Unless unordered is set, batches which finish early are
+// buffered so that records are written in listing order.
+// The capacity of inFlight bounds the size of this buffer.
+func writeResults(w *autorot.RecordWriter, results <-chan *batch, inFlight <-chan struct{},
+	unordered bool) {
+	pending := map[int]*batch{}
+	var next int
+	for b := range results {
+		if unordered {
+			writeRecords(w, b.Records)
+			<-inFlight
+			continue
+		}
+		pending[b.Index] = b
+		for pending[next] != nil {
+			writeRecords(w, pending[next].Records)
+			delete(pending, next)
+			next++
+			<-inFlight
+		}
+	}
+}
+
+func writeRecords(w *autorot.RecordWriter, records []*autorot.Record) {
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			essentials.Die("Write output failed:", err)
+		}
+	}
+}
+
+// A processor classifies batches of images.
+type processor struct {
+	Evaluate  evalFunc
+	Model     string
+	Corrector *Corrector
+
+	// Resume, if non-nil, lists images to skip.
+	Resume *Resume
+
+	// Hash indicates that file hashes should be recorded.
+	Hash bool
+}
+
+// Process classifies a batch and sets its records.
+//
+// Images which cannot be read produce records with an
+// error instead of a prediction.
+func (p *processor) Process(b *batch) {
+	var imgs []image.Image
+	var imgRecords []*autorot.Record
+	for i, imgPath := range b.Paths {
+		if p.Resume != nil && p.Resume.Skip(imgPath, b.Infos[i]) {
+			continue
+		}
+		rec := &autorot.Record{Path: imgPath, Model: p.Model}
+		b.Records = append(b.Records, rec)
+		if err := stampFile(rec, b.Infos[i], p.Hash); err != nil {
+			rec.Error = err.Error()
+			continue
+		}
+		img, format, err := autorot.ReadImage(imgPath)
+		if err != nil {
+			rec.Error = err.Error()
+			continue
+		}
+		rec.Width = img.Bounds().Dx()
+		rec.Height = img.Bounds().Dy()
+		rec.Format = format
+		imgs = append(imgs, img)
+		imgRecords = append(imgRecords, rec)
+	}
+	if len(imgs) == 0 {
+		return
+	}
+	angles, confidences, probs := p.Evaluate(imgs)
+	for i, rec := range imgRecords {
+		rec.SetPrediction(angles[i], confidences[i])
+		if probs != nil {
+			rec.Probs = probs[i][:]
+		}
+		if p.Corrector != nil {
+			_, err := p.Corrector.Correct(rec.Path, imgs[i], rec.Format, angles[i],
+				confidences[i])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
 	}
-	angle, confidence := network.Evaluate(img)
-	w.Write([]string{imgPath, fmt.Sprintf("%f", angle), fmt.Sprintf("%f", confidence)})
-	w.Flush()
-	return nil
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/unixpickle/autorot"
+)
+
+// Verification modes for resumed runs.
+const (
+	VerifyNone = "none"
+	VerifyStat = "stat"
+	VerifyHash = "hash"
+)
+
+// stampFile fills in the fields of a record which
+// identify the version of the file being classified.
+func stampFile(rec *autorot.Record, info os.FileInfo, withHash bool) error {
+	rec.Size = info.Size()
+	rec.ModTime = info.ModTime().UnixNano()
+	if withHash {
+		var err error
+		rec.Hash, err = autorot.HashFile(rec.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Resume tracks the images which were classified by a
+// previous, interrupted run.
+type Resume struct {
+	Verify string
+	Format string
+
+	// Model identifies the current evaluator.
+	// Unless Verify is VerifyNone, resuming fails if the
+	// previous run used a different model, since the
+	// output would mix the predictions of both.
+	Model string
+
+	done map[string]*autorot.Record
+}
+
+// OpenOutput opens an output file for classification
+// results.
+//
+// If resume is nil, the file must not already exist.
+// Otherwise, the records in an existing file are loaded
+// into resume, any truncated final record is removed, and
+// the file is opened for appending.
+//
+// The second return value indicates if the file is empty,
+// in which case a header should be written.
+func OpenOutput(path string, resume *Resume) (*os.File, bool, error) {
+	if resume == nil {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			err = errors.New("open output: " + path + " exists (use -resume to continue it)")
+		}
+		return f, true, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	// Records are flushed one line at a time, so anything
+	// after the last newline is an interrupted write.
+	complete := data[:bytes.LastIndexByte(data, '\n')+1]
+	if err := resume.load(complete); err != nil {
+		f.Close()
+		return nil, false, errors.New("resume " + path + ": " + err.Error())
+	}
+	if err := f.Truncate(int64(len(complete))); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, len(complete) == 0, nil
+}
+
+func (r *Resume) load(data []byte) error {
+	records, err := autorot.ReadRecords(bytes.NewReader(data), r.Format)
+	if err != nil {
+		return err
+	}
+	r.done = map[string]*autorot.Record{}
+	for _, rec := range records {
+		if rec.Error == "" {
+			if r.Verify != VerifyNone && r.Model != "" && rec.Model != r.Model {
+				return errors.New("records from a different model (" + rec.Model +
+					"); use -verify none to keep them")
+			}
+			r.done[rec.Path] = rec
+		} else {
+			// Failures may be transient, so they are retried.
+			delete(r.done, rec.Path)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of previously classified images.
+func (r *Resume) Len() int {
+	return len(r.done)
+}
+
+// Skip checks if an image was already classified.
+//
+// With VerifyStat or VerifyHash, the image is only skipped
+// if it has not changed since it was classified.
+func (r *Resume) Skip(path string, info os.FileInfo) bool {
+	old, ok := r.done[path]
+	if !ok {
+		return false
+	}
+	switch r.Verify {
+	case VerifyNone:
+		return true
+	case VerifyStat:
+		return old.Size == info.Size() && old.ModTime == info.ModTime().UnixNano()
+	case VerifyHash:
+		if old.Hash == "" || old.Size != info.Size() {
+			return false
+		}
+		hash, err := autorot.HashFile(path)
+		return err == nil && hash == old.Hash
+	default:
+		panic("unknown verification mode: " + r.Verify)
+	}
+}
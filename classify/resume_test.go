@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/autorot"
+)
+
+func TestOpenOutputTruncated(t *testing.T) {
+	for _, format := range []string{autorot.CSVRecords, autorot.JSONLRecords} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		outPath := filepath.Join(dir, "out")
+
+		var buf bytes.Buffer
+		w, err := autorot.NewRecordWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader()
+		for _, name := range []string{"a.png", "b.png"} {
+			w.Write(&autorot.Record{Path: filepath.Join(dir, name), Model: "m"})
+		}
+		complete := buf.Len()
+		buf.WriteString(filepath.Join(dir, "c.pn"))
+		if err := ioutil.WriteFile(outPath, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := OpenOutput(outPath, nil); err == nil {
+			t.Errorf("%s: expected error for existing output", format)
+		}
+
+		resume := &Resume{Verify: VerifyNone, Format: format, Model: "m"}
+		f, needsHeader, err := OpenOutput(outPath, resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if needsHeader {
+			t.Errorf("%s: unexpected header request", format)
+		}
+		if resume.Len() != 2 {
+			t.Errorf("%s: expected 2 records but got %d", format, resume.Len())
+		}
+		f.WriteString("next\n")
+		f.Close()
+		data, err := ioutil.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := string(buf.Bytes()[:complete]) + "next\n"
+		if string(data) != expected {
+			t.Errorf("%s: expected %q but got %q", format, expected, data)
+		}
+
+		if !resume.Skip(filepath.Join(dir, "a.png"), nil) {
+			t.Errorf("%s: finished path was not skipped", format)
+		}
+		if resume.Skip(filepath.Join(dir, "c.pn"), nil) {
+			t.Errorf("%s: truncated path was skipped", format)
+		}
+	}
+}
+
+func TestResumeVerify(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	imgPath := filepath.Join(dir, "a.png")
+	failedPath := filepath.Join(dir, "b.png")
+	for _, path := range []string{imgPath, failedPath} {
+		if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(imgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &autorot.Record{Path: imgPath, Model: "m"}
+	if err := stampFile(rec, info, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := autorot.NewRecordWriter(&buf, autorot.JSONLRecords)
+	w.Write(rec)
+	w.Write(&autorot.Record{Path: failedPath, Model: "m", Error: "decode failed"})
+	outPath := filepath.Join(dir, "out.jsonl")
+
+	openResume := func(verify, model string) (*Resume, error) {
+		if err := ioutil.WriteFile(outPath, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := &Resume{Verify: verify, Format: autorot.JSONLRecords, Model: model}
+		f, _, err := OpenOutput(outPath, r)
+		if err == nil {
+			f.Close()
+		}
+		return r, err
+	}
+
+	for _, verify := range []string{VerifyNone, VerifyStat, VerifyHash} {
+		r, err := openResume(verify, "m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Skip(imgPath, info) {
+			t.Errorf("%s: unchanged file was not skipped", verify)
+		}
+		if r.Skip(failedPath, info) {
+			t.Errorf("%s: failed file was skipped", verify)
+		}
+	}
+
+	// Same size and contents, but a new modification time.
+	newTime := time.Unix(0, rec.ModTime).Add(time.Hour)
+	if err := os.Chtimes(imgPath, newTime, newTime); err != nil {
+		t.Fatal(err)
+	}
+	touched, err := os.Stat(imgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := openResume(VerifyStat, "m")
+	if r.Skip(imgPath, touched) {
+		t.Error("stat: touched file was skipped")
+	}
+	r, _ = openResume(VerifyHash, "m")
+	if !r.Skip(imgPath, touched) {
+		t.Error("hash: touched file was not skipped")
+	}
+
+	// Same size, but different contents.
+	if err := ioutil.WriteFile(imgPath, []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := os.Stat(imgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Skip(imgPath, changed) {
+		t.Error("hash: changed file was skipped")
+	}
+
+	if _, err := openResume(VerifyStat, "other"); err == nil {
+		t.Error("expected error for a different model")
+	}
+	if _, err := openResume(VerifyNone, "other"); err != nil {
+		t.Errorf("unexpected error without verification: %v", err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "autorot-classify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
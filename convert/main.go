@@ -0,0 +1,68 @@
+// Command convert changes the output type of an autorot
+// network by replacing its output layer, keeping the
+// trained backbone and hidden layers.
+//
+// The converted network can be retrained quickly with
+// train -head-only.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	var inFile string
+	var outFile string
+	var typeName string
+	var notes string
+	flag.StringVar(&inFile, "in", "", "input network path")
+	flag.StringVar(&outFile, "out", "", "output network path")
+	flag.StringVar(&typeName, "type", "",
+		"new output type (RawAngle, RightAngles, or ConfidenceAngle)")
+	flag.StringVar(&notes, "notes", "", "notes to store in the network metadata")
+	flag.Parse()
+
+	if inFile == "" || outFile == "" || typeName == "" {
+		essentials.Die("Required flags: -in, -out, and -type. See -help for more.")
+	}
+
+	outType := autorot.OutputType(-1)
+	for _, t := range []autorot.OutputType{autorot.RawAngle, autorot.RightAngles,
+		autorot.ConfidenceAngle} {
+		if t.String() == typeName {
+			outType = t
+		}
+	}
+	if outType < 0 {
+		essentials.Die("Unknown output type:", typeName)
+	}
+
+	var net *autorot.Net
+	if err := serializer.LoadAny(inFile, &net); err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	oldType := net.OutputType
+	initialized, err := net.ConvertOutputType(anyvec32.CurrentCreator(), outType)
+	if err != nil {
+		essentials.Die(err)
+	}
+	if initialized {
+		log.Printf("Converted %s to %s, initializing from the old output layer.",
+			oldType, outType)
+	} else {
+		log.Printf("Converted %s to %s with a new output layer.", oldType, outType)
+	}
+	if notes != "" {
+		net.Metadata.Notes = notes
+	}
+
+	if err := serializer.SaveAny(outFile, net); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+}
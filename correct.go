@@ -0,0 +1,149 @@
+package autorot
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// CorrectionTurns returns the number of clockwise quarter
+// turns which undo a predicted rotation, after snapping
+// the prediction to the nearest right angle.
+func CorrectionTurns(angle float64) int {
+	return (4 - NearestRightAngle(angle)) % 4
+}
+
+// Correct rotates an image to undo a predicted rotation.
+//
+// If snap is true, the angle is snapped to the nearest
+// right angle and the image is rotated without cropping or
+// resampling.
+// Otherwise, the image is rotated by the exact angle onto
+// a canvas that fits the entire rotated image.
+func Correct(img image.Image, angle float64, snap bool) image.Image {
+	if snap {
+		return RotateRightAngle(img, CorrectionTurns(angle))
+	}
+	return RotateExpand(img, -angle)
+}
+
+// Modes for CorrectFile.
+const (
+	// ExifMode only corrects JPEGs with an orientation tag.
+	ExifMode = "exif"
+
+	// RewriteMode always re-encodes the image.
+	RewriteMode = "rewrite"
+
+	// AutoMode uses ExifMode when possible and falls back
+	// to RewriteMode.
+	AutoMode = "auto"
+)
+
+// A FileCorrection is the result of CorrectFile.
+type FileCorrection struct {
+	// Data is the corrected file.
+	Data []byte
+
+	// Transform is ExifTransform or RewriteTransform.
+	Transform string
+
+	// OldOrientation and NewOrientation are set for
+	// ExifTransform.
+	OldOrientation int
+	NewOrientation int
+}
+
+// CorrectFile corrects the encoded data of a JPEG or PNG
+// file, given the decoded image and a predicted angle.
+// The correction is snapped to a right angle.
+//
+// With ExifMode or AutoMode, a JPEG with an orientation
+// tag is corrected by changing the tag, leaving the pixels
+// untouched.
+// Otherwise, the image is rotated and re-encoded in the
+// same format with the given JPEG quality.
+//
+// It returns nil if the file does not need to change.
+func CorrectFile(data []byte, img image.Image, angle float64, mode string,
+	quality int) (*FileCorrection, error) {
+	turns := CorrectionTurns(angle)
+	exif, exifOffset := JPEGExif(data)
+	oldOrientation, hasOrientation := ExifOrientation(exif)
+	_, pureRotation := TurnsForOrientation(oldOrientation)
+	if mode != RewriteMode && hasOrientation && pureRotation {
+		// The net saw the stored pixels, so the new tag
+		// replaces the old one rather than adding to it.
+		newOrientation := OrientationForTurns(turns)
+		if newOrientation == oldOrientation {
+			return nil, nil
+		}
+		newData := append([]byte{}, data...)
+		payload := newData[exifOffset : exifOffset+len(exif)]
+		if err := SetExifOrientation(payload, newOrientation); err != nil {
+			return nil, err
+		}
+		return &FileCorrection{
+			Data:           newData,
+			Transform:      ExifTransform,
+			OldOrientation: oldOrientation,
+			NewOrientation: newOrientation,
+		}, nil
+	}
+	if turns == 0 {
+		return nil, nil
+	} else if mode == ExifMode {
+		return nil, errors.New("correct file: no EXIF orientation tag to modify")
+	}
+	format := "png"
+	if bytes.HasPrefix(data, []byte{0xff, jpegSOI}) {
+		format = "jpeg"
+	}
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, RotateRightAngle(img, turns), format, quality, exif); err != nil {
+		return nil, err
+	}
+	return &FileCorrection{Data: buf.Bytes(), Transform: RewriteTransform}, nil
+}
+
+// EncodeImage encodes an image as "jpeg" or "png".
+//
+// For JPEGs, the quality ranges from 1 to 100, and exif
+// may be an EXIF payload (as returned by JPEGExif) to
+// embed in the output.
+// Since the pixels of a corrected image are upright, the
+// orientation tag of the embedded EXIF data is reset.
+// The exif argument is not modified.
+func EncodeImage(w io.Writer, img image.Image, format string, quality int,
+	exif []byte) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+		data := buf.Bytes()
+		if exif != nil {
+			exif = append([]byte{}, exif...)
+			if _, ok := ExifOrientation(exif); ok {
+				if err := SetExifOrientation(exif, 1); err != nil {
+					return err
+				}
+			}
+			var err error
+			data, err = InsertJPEGExif(data, exif)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(data)
+		return err
+	default:
+		return errors.New("encode image: unsupported format " + format)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// An arch describes a built-in feature extractor made of
+// conv blocks, each of which halves the spatial size.
+type arch struct {
+	Description string
+
+	// Filters contains the number of filters for each
+	// block.
+	Filters []int
+
+	// ConvsPerBlock is the number of 3x3 convolutions in
+	// each block.
+	ConvsPerBlock int
+}
+
+var archs = map[string]arch{
+	"tiny": {
+		Description:   "4 blocks of 1 conv, 16-128 filters",
+		Filters:       []int{16, 32, 64, 128},
+		ConvsPerBlock: 1,
+	},
+	"small": {
+		Description:   "5 blocks of 2 convs, 16-256 filters",
+		Filters:       []int{16, 32, 64, 128, 256},
+		ConvsPerBlock: 2,
+	},
+	"medium": {
+		Description:   "5 blocks of 3 convs, 32-512 filters",
+		Filters:       []int{32, 64, 128, 256, 512},
+		ConvsPerBlock: 3,
+	},
+}
+
+// archNames returns the sorted names of the built-in
+// architectures.
+func archNames() []string {
+	var res []string
+	for name := range archs {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Markup generates anyconv markup for the architecture.
+//
+// The output is globally average-pooled, so the feature
+// count does not depend on the input size.
+func (a arch) Markup(inputSize int) (string, error) {
+	reduction := 1 << uint(len(a.Filters))
+	if inputSize%reduction != 0 {
+		return "", fmt.Errorf("input size must be divisible by %d", reduction)
+	} else if inputSize < reduction {
+		return "", errors.New("input size is too small")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Input(w=%d, h=%d, d=3)\n", inputSize, inputSize)
+	for _, filters := range a.Filters {
+		for i := 0; i < a.ConvsPerBlock; i++ {
+			buf.WriteString("Padding(t=1, b=1, l=1, r=1)\n")
+			fmt.Fprintf(&buf, "Conv(w=3, h=3, n=%d)\n", filters)
+			buf.WriteString("BatchNorm\n")
+			buf.WriteString("ReLU\n")
+		}
+		buf.WriteString("MaxPool(w=2, h=2)\n")
+	}
+	finalSize := inputSize / reduction
+	if finalSize > 1 {
+		fmt.Fprintf(&buf, "MeanPool(w=%d, h=%d)\n", finalSize, finalSize)
+	}
+	return buf.String(), nil
+}
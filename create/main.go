@@ -0,0 +1,162 @@
+// Command create builds a new, randomly initialized
+// autorot network which can then be trained with train.
+//
+// The feature extractor is either a built-in architecture
+// or an anyconv markup file.
+// A markup file's Input layer sets the input size.
+// An output layer for the chosen output type is added on
+// top of the features.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	var outFile string
+	var archName string
+	var markupFile string
+	var inputSize int
+	var rightAngles bool
+	var confidence bool
+	var letterbox bool
+	var notes string
+
+	flag.StringVar(&outFile, "out", "", "output network path")
+	flag.StringVar(&archName, "arch", "small",
+		"built-in architecture ("+strings.Join(archNames(), ", ")+")")
+	flag.StringVar(&markupFile, "markup", "",
+		"anyconv markup file for a feature extractor (overrides -arch and -size)")
+	flag.IntVar(&inputSize, "size", 128, "side length of input images")
+	flag.BoolVar(&rightAngles, "rightangles", false, "use right angles")
+	flag.BoolVar(&confidence, "confidence", false, "use confidence and angle outputs")
+	flag.BoolVar(&letterbox, "letterbox", false, "fit whole images into the input with padding")
+	flag.StringVar(&notes, "notes", "", "notes to store in the network metadata")
+
+	flag.Parse()
+
+	if outFile == "" {
+		essentials.Die("Required flags: -out. See -help for more.")
+	}
+
+	var markup string
+	if markupFile != "" {
+		data, err := ioutil.ReadFile(markupFile)
+		if err != nil {
+			essentials.Die("Read markup failed:", err)
+		}
+		markup = string(data)
+		size, err := markupInputSize(markup)
+		if err != nil {
+			essentials.Die("Markup "+markupFile+":", err)
+		}
+		if sizeFlagSet() && size != inputSize {
+			essentials.Die(fmt.Sprintf("Markup input size %d does not match -size %d.", size,
+				inputSize))
+		}
+		inputSize = size
+	} else {
+		a, ok := archs[archName]
+		if !ok {
+			essentials.Die("Unknown architecture:", archName)
+		}
+		var err error
+		markup, err = a.Markup(inputSize)
+		if err != nil {
+			essentials.Die("Architecture "+archName+":", err)
+		}
+	}
+
+	c := anyvec32.CurrentCreator()
+	features, err := anyconv.FromMarkup(c, markup)
+	if err != nil {
+		essentials.Die("Parse markup failed:", err)
+	}
+
+	outType := autorot.RawAngle
+	if rightAngles {
+		outType = autorot.RightAngles
+	} else if confidence {
+		outType = autorot.ConfidenceAngle
+	}
+
+	featureCount := autorot.FeatureCount(c, features, inputSize)
+	head := autorot.NewHead(c, featureCount, outType)
+	net := &autorot.Net{
+		InputSize:  inputSize,
+		OutputType: outType,
+		Net:        append(features, head...),
+		Metadata:   autorot.NewMetadata(),
+	}
+	net.Metadata.HeadLayers = len(head)
+	if letterbox {
+		net.InputMode = autorot.Letterboxed
+	}
+	net.Metadata.Notes = notes
+	if net.Metadata.Notes == "" {
+		if markupFile != "" {
+			net.Metadata.Notes = "created from " + markupFile
+		} else {
+			net.Metadata.Notes = "created with architecture " + archName
+		}
+	}
+
+	log.Printf("Created network with %d features and %d parameters.", featureCount,
+		countParams(net))
+	if err := serializer.SaveAny(outFile, net); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+}
+
+// markupInputSize finds the side length of the square,
+// three-channel input of an anyconv markup file.
+func markupInputSize(markup string) (int, error) {
+	match := inputExpr.FindStringSubmatch(markup)
+	if match == nil {
+		return 0, errors.New("missing Input layer")
+	}
+	dims := map[string]int{}
+	for _, param := range paramExpr.FindAllStringSubmatch(match[1], -1) {
+		dims[param[1]], _ = strconv.Atoi(param[2])
+	}
+	if dims["w"] <= 0 || dims["w"] != dims["h"] || dims["d"] != 3 {
+		return 0, fmt.Errorf("input must be square with d=3, not %s", match[0])
+	}
+	return dims["w"], nil
+}
+
+var (
+	inputExpr = regexp.MustCompile(`Input\s*\(([^)]*)\)`)
+	paramExpr = regexp.MustCompile(`(\w+)\s*=\s*(\d+)`)
+)
+
+func sizeFlagSet() bool {
+	var res bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "size" {
+			res = true
+		}
+	})
+	return res
+}
+
+func countParams(net *autorot.Net) int {
+	var res int
+	for _, p := range net.Net.Parameters() {
+		res += p.Vector.Len()
+	}
+	return res
+}
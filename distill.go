@@ -0,0 +1,132 @@
+package autorot
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+// A Teacher produces the soft targets for distillation.
+// Both *Net and *Ensemble implement it.
+type Teacher interface {
+	EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+		probs [][4]float64)
+}
+
+// A Distiller trains a student Net to mimic a teacher on
+// unlabeled images.
+//
+// It is both an anysgd.Fetcher and an anynet.Coster, so it
+// can take the place of an anyff.Trainer's Fetch method
+// and a Net's Cost method during training.
+//
+// Images are rotated the same way as in SampleList, but
+// the targets come from the teacher's predictions on the
+// rotated images rather than from the rotations.
+// For RightAngles students, the target is the teacher's
+// full right angle distribution.
+// For other students, the target is the teacher's angle.
+type Distiller struct {
+	Teacher Teacher
+	Student *Net
+
+	// Temperature flattens (if greater than 1) or sharpens
+	// (if less than 1) the teacher's distribution before it
+	// is used as a target.
+	// It only applies to RightAngles students.
+	// A value of 0 is treated as 1.
+	Temperature float64
+}
+
+// Fetch produces an *anyff.Batch for a *SampleList.
+//
+// The teacher is evaluated on the whole batch at once.
+func (d *Distiller) Fetch(s anysgd.SampleList) (anysgd.Batch, error) {
+	samples, ok := s.(*SampleList)
+	if !ok {
+		return nil, fmt.Errorf("distill: unsupported sample list %T", s)
+	}
+	var inputs []float32
+	views := make([]image.Image, samples.Len())
+	for i, path := range samples.Paths {
+		img, _, err := ReadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		angle := samples.rotation(i)
+		if samples.Orientations != nil {
+			angle -= samples.Orientations[i]
+		}
+		views[i] = rotateView(img, angle)
+		studentIn := fitInput(img, angle, d.Student.InputSize, d.Student.InputMode)
+		inputs = append(inputs, netInputTensor(studentIn)...)
+	}
+
+	angles, _, probs := d.Teacher.EvaluateProbs(views)
+	var outputs []float32
+	for i := range views {
+		if d.Student.OutputType == RightAngles {
+			dist := temperProbs(probs[i], d.Temperature)
+			for _, p := range dist {
+				outputs = append(outputs, float32(p))
+			}
+		} else {
+			outputs = append(outputs, float32(angles[i]))
+		}
+	}
+
+	return &anyff.Batch{
+		Inputs:  anydiff.NewConst(anyvec32.MakeVectorData(inputs)),
+		Outputs: anydiff.NewConst(anyvec32.MakeVectorData(outputs)),
+		Num:     len(views),
+	}, nil
+}
+
+// Cost computes the distillation cost.
+//
+// For RightAngles students, this is the cross entropy
+// between the teacher's distribution and the student's.
+// Otherwise, it is the student's usual cost with the
+// teacher's angles as the desired outputs.
+func (d *Distiller) Cost(desired, actual anydiff.Res, num int) anydiff.Res {
+	if d.Student.OutputType == RightAngles {
+		return anynet.DotCost{}.Cost(desired, actual, num)
+	}
+	return d.Student.Cost(desired, actual, num)
+}
+
+// temperProbs applies a temperature to a distribution.
+func temperProbs(probs [4]float64, temperature float64) [4]float64 {
+	if temperature == 0 || temperature == 1 {
+		return probs
+	}
+	var res [4]float64
+	var sum float64
+	for i, p := range probs {
+		res[i] = math.Pow(p, 1/temperature)
+		sum += res[i]
+	}
+	if sum == 0 {
+		return [4]float64{0.25, 0.25, 0.25, 0.25}
+	}
+	for i := range res {
+		res[i] /= sum
+	}
+	return res
+}
+
+// rotateView rotates an entire image clockwise.
+// Right angle rotations do not resample the image.
+func rotateView(img image.Image, angle float64) image.Image {
+	turns := NearestRightAngle(angle)
+	if AngleDiff(angle, float64(turns)*math.Pi/2) < 1e-5 {
+		return RotateRightAngle(img, turns)
+	}
+	return RotateExpand(img, angle)
+}
@@ -0,0 +1,242 @@
+// Command distill trains a small student network to mimic
+// a larger teacher network (or ensemble) on unlabeled
+// images.
+//
+// When training is interrupted, the student is saved and
+// the teacher and student are compared on held-out images
+// in terms of size, speed, and accuracy.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/rip"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+	var teacherPath string
+	var studentPath string
+	var dataDir string
+	var manifestPath string
+	var temperature float64
+	var stepSize float64
+	var batchSize int
+	var numHeldOut int
+	flag.StringVar(&teacherPath, "teacher", "", "teacher network or ensemble")
+	flag.StringVar(&studentPath, "student", "", "student network file (e.g. from create)")
+	flag.StringVar(&dataDir, "data", "", "directory of unlabeled upright images")
+	flag.StringVar(&manifestPath, "manifest", "", "label manifest of images with known orientations")
+	flag.Float64Var(&temperature, "temperature", 1,
+		"temperature for the teacher's right angle distribution")
+	flag.Float64Var(&stepSize, "step", 0.001, "SGD step size")
+	flag.IntVar(&batchSize, "batch", 12, "SGD batch size")
+	flag.IntVar(&numHeldOut, "heldout", 64, "number of images held out for the final comparison")
+	flag.Parse()
+
+	if teacherPath == "" || studentPath == "" || (dataDir == "" && manifestPath == "") {
+		essentials.Die("Required flags: -teacher, -student, and -data (or -manifest). " +
+			"See -help for more.")
+	}
+	if temperature <= 0 {
+		essentials.Die("Temperature must be positive.")
+	}
+
+	log.Println("Loading networks...")
+	teacherModel, err := autorot.LoadEvaluator(teacherPath)
+	if err != nil {
+		essentials.Die("Load teacher failed:", err)
+	}
+	teacher, ok := teacherModel.(autorot.Teacher)
+	if !ok {
+		essentials.Die("Unsupported teacher type.")
+	}
+	var student *autorot.Net
+	if err := serializer.LoadAny(studentPath, &student); err != nil {
+		essentials.Die("Load student failed:", err)
+	}
+
+	log.Println("Loading samples...")
+	samples := &autorot.SampleList{ImageSize: student.InputSize}
+	if dataDir != "" {
+		dirSamples, err := autorot.ReadSampleList(student.InputSize, dataDir)
+		if err != nil {
+			essentials.Die("Load data failed:", err)
+		}
+		samples.Append(dirSamples)
+	}
+	if manifestPath != "" {
+		labeled, err := autorot.ReadManifest(student.InputSize, manifestPath)
+		if err != nil {
+			essentials.Die("Load manifest failed:", err)
+		}
+		samples.Append(labeled)
+	}
+	samples.InputMode = student.InputMode
+	anysgd.Shuffle(samples)
+	if numHeldOut >= samples.Len() {
+		essentials.Die("Not enough samples to hold out", numHeldOut, "images.")
+	}
+	heldOut := samples.Slice(0, numHeldOut).(*autorot.SampleList)
+	samples = samples.Slice(numHeldOut, samples.Len()).(*autorot.SampleList)
+
+	log.Println("Distilling...")
+
+	autorot.SetDropout(student.Net, true)
+
+	d := &autorot.Distiller{
+		Teacher:     teacher,
+		Student:     student,
+		Temperature: temperature,
+	}
+	t := &anyff.Trainer{
+		Net:     student.Net,
+		Cost:    d,
+		Params:  student.Net.Parameters(),
+		Average: true,
+	}
+
+	var iterNum int
+	s := &anysgd.SGD{
+		Fetcher:     d,
+		Gradienter:  t,
+		Transformer: &anysgd.Adam{},
+		Samples:     samples,
+		Rater:       anysgd.ConstRater(stepSize),
+		BatchSize:   batchSize,
+		StatusFunc: func(b anysgd.Batch) {
+			log.Printf("iter %d: cost=%v", iterNum, t.LastCost)
+			iterNum++
+		},
+	}
+
+	s.Run(rip.NewRIP().Chan())
+
+	autorot.SetDropout(student.Net, false)
+	student.Metadata.Iterations += iterNum
+	student.Metadata.DatasetHash = samples.Hash()
+
+	log.Println("Saving network...")
+	if err := serializer.SaveAny(studentPath, student); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+
+	if heldOut.Len() > 0 {
+		log.Println("Comparing on", heldOut.Len(), "held-out images...")
+		compare(teacherModel, student, heldOut, batchSize)
+	}
+}
+
+// A summary describes how a model did on the held-out
+// images.
+type summary struct {
+	Params  int
+	Elapsed time.Duration
+	Correct int
+	Total   int
+	Angles  []float64
+}
+
+// compare evaluates the teacher and student on every
+// right angle rotation of the held-out images and prints
+// a comparison.
+func compare(teacher autorot.Evaluator, student *autorot.Net,
+	heldOut *autorot.SampleList, batchSize int) {
+	var views []image.Image
+	var truth []float64
+	for i, path := range heldOut.Paths {
+		img, _, err := autorot.ReadImage(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		var orientation float64
+		if heldOut.Orientations != nil {
+			orientation = heldOut.Orientations[i]
+		}
+		upright := autorot.RotateRightAngle(img, (4-autorot.NearestRightAngle(orientation))%4)
+		for turns := 0; turns < 4; turns++ {
+			views = append(views, autorot.RotateRightAngle(upright, turns))
+			truth = append(truth, float64(turns)*math.Pi/2)
+		}
+	}
+
+	teacherRes := evaluate(teacher, views, truth, batchSize)
+	teacherRes.Params = countParams(teacher)
+	studentRes := evaluate(student, views, truth, batchSize)
+	studentRes.Params = countParams(student)
+
+	var agree int
+	for i, angle := range studentRes.Angles {
+		if autorot.NearestRightAngle(angle) == autorot.NearestRightAngle(teacherRes.Angles[i]) {
+			agree++
+		}
+	}
+
+	fmt.Printf("%-8s %12s %14s %10s\n", "model", "parameters", "ms/image", "accuracy")
+	for _, row := range []struct {
+		Name string
+		Res  *summary
+	}{{"teacher", teacherRes}, {"student", studentRes}} {
+		perImage := row.Res.Elapsed.Seconds() * 1000 / float64(len(views))
+		fmt.Printf("%-8s %12d %14.3f %9.2f%%\n", row.Name, row.Res.Params, perImage,
+			100*float64(row.Res.Correct)/float64(row.Res.Total))
+	}
+	fmt.Printf("\nStudent is %.1fx smaller and %.1fx faster, agreeing with the teacher "+
+		"on %.2f%% of images.\n",
+		float64(teacherRes.Params)/math.Max(1, float64(studentRes.Params)),
+		teacherRes.Elapsed.Seconds()/math.Max(1e-9, studentRes.Elapsed.Seconds()),
+		100*float64(agree)/float64(len(views)))
+}
+
+func evaluate(model autorot.Evaluator, views []image.Image, truth []float64,
+	batchSize int) *summary {
+	res := &summary{Total: len(views)}
+	start := time.Now()
+	for i := 0; i < len(views); i += batchSize {
+		end := i + batchSize
+		if end > len(views) {
+			end = len(views)
+		}
+		angles, _ := model.EvaluateBatch(views[i:end])
+		res.Angles = append(res.Angles, angles...)
+	}
+	res.Elapsed = time.Since(start)
+	for i, angle := range res.Angles {
+		if autorot.NearestRightAngle(angle) == autorot.NearestRightAngle(truth[i]) {
+			res.Correct++
+		}
+	}
+	return res
+}
+
+func countParams(model autorot.Evaluator) int {
+	var nets []*autorot.Net
+	switch model := model.(type) {
+	case *autorot.Net:
+		nets = []*autorot.Net{model}
+	case *autorot.Ensemble:
+		nets = model.Nets
+	}
+	var res int
+	for _, net := range nets {
+		for _, p := range net.Net.Parameters() {
+			res += p.Vector.Len()
+		}
+	}
+	return res
+}
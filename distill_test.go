@@ -0,0 +1,112 @@
+package autorot
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/unixpickle/anynet/anyff"
+)
+
+func TestTemperProbs(t *testing.T) {
+	probs := [4]float64{0.7, 0.1, 0.15, 0.05}
+	if temperProbs(probs, 1) != probs || temperProbs(probs, 0) != probs {
+		t.Error("unit temperature should not change the distribution")
+	}
+	soft := temperProbs(probs, 4)
+	sharp := temperProbs(probs, 0.5)
+	var softSum, sharpSum float64
+	for i := range probs {
+		softSum += soft[i]
+		sharpSum += sharp[i]
+	}
+	if math.Abs(softSum-1) > 1e-8 || math.Abs(sharpSum-1) > 1e-8 {
+		t.Errorf("distributions should sum to 1 but got %f and %f", softSum, sharpSum)
+	}
+	if !(sharp[0] > probs[0] && probs[0] > soft[0]) {
+		t.Errorf("bad ordering: %v %v %v", sharp, probs, soft)
+	}
+	if soft[1] <= probs[1] {
+		t.Error("high temperature should raise small probabilities")
+	}
+}
+
+func TestDistillerFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autorot-distill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	samples := &SampleList{ImageSize: 6}
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		path := filepath.Join(dir, name)
+		writeTestPNG(t, path, randomImage(8, 5))
+		samples.Paths = append(samples.Paths, path)
+	}
+
+	teacher := &constTeacher{Probs: [4]float64{0.7, 0.1, 0.15, 0.05}}
+	for _, outType := range []OutputType{RightAngles, RawAngle} {
+		d := &Distiller{Teacher: teacher, Student: testNet(6, outType), Temperature: 2}
+		batch, err := d.Fetch(samples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := batch.(*anyff.Batch)
+		if b.Num != 3 || b.Inputs.Output().Len() != 3*6*6*3 {
+			t.Fatalf("type %s: bad batch size", outType)
+		}
+		outputs := b.Outputs.Output().Data().([]float32)
+		if outType == RightAngles {
+			expected := temperProbs(teacher.Probs, 2)
+			if len(outputs) != 12 {
+				t.Fatalf("expected 12 outputs but got %d", len(outputs))
+			}
+			for i, x := range outputs {
+				if math.Abs(float64(x)-expected[i%4]) > 1e-5 {
+					t.Errorf("output %d: expected %f but got %f", i, expected[i%4], x)
+				}
+			}
+		} else {
+			for i, x := range outputs {
+				if x != math.Pi/2 {
+					t.Errorf("output %d: expected teacher angle but got %f", i, x)
+				}
+			}
+		}
+		cost := d.Cost(b.Outputs, d.Student.Net.Apply(b.Inputs, b.Num), b.Num)
+		if cost.Output().Len() != b.Num {
+			t.Errorf("type %s: bad cost length", outType)
+		}
+	}
+}
+
+// A constTeacher predicts the same distribution for every
+// image, with an angle of 90 degrees.
+type constTeacher struct {
+	Probs [4]float64
+}
+
+func (c *constTeacher) EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+	probs [][4]float64) {
+	for range imgs {
+		angles = append(angles, math.Pi/2)
+		confidences = append(confidences, 1)
+		probs = append(probs, c.Probs)
+	}
+	return
+}
+
+func writeTestPNG(t *testing.T, path string, img image.Image) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
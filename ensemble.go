@@ -0,0 +1,331 @@
+package autorot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/unixpickle/serializer"
+)
+
+// Fusion specifies how an Ensemble combines the
+// predictions of its nets.
+type Fusion int
+
+const (
+	// CircularFusion computes a weighted circular mean of
+	// the predicted angles, weighting each net's prediction
+	// by its confidence.
+	CircularFusion Fusion = iota
+
+	// ProbabilityFusion averages the probabilities that each
+	// net assigns to each right angle and picks the most
+	// likely right angle.
+	// See Net.RightAngleProbs for how nets that do not
+	// produce right angle probabilities are handled.
+	ProbabilityFusion
+)
+
+func init() {
+	var e Ensemble
+	serializer.RegisterTypedDeserializer(e.SerializerType(), DeserializeEnsemble)
+}
+
+// An Ensemble combines the predictions of multiple nets.
+//
+// The nets may have different input sizes, input modes,
+// and output types.
+type Ensemble struct {
+	Nets []*Net
+
+	// Weights contains one positive weight per net.
+	// If it is nil, every net is weighted equally.
+	Weights []float64
+
+	Fusion Fusion
+}
+
+// DeserializeEnsemble deserializes an Ensemble.
+func DeserializeEnsemble(d []byte) (*Ensemble, error) {
+	var res Ensemble
+	var netData []byte
+	err := serializer.DeserializeAny(d, &res.Fusion, &res.Weights, &netData)
+	if err != nil {
+		return nil, errors.New("deserialize ensemble: " + err.Error())
+	}
+	nets, err := serializer.DeserializeSlice(netData)
+	if err != nil {
+		return nil, errors.New("deserialize ensemble: " + err.Error())
+	}
+	for _, obj := range nets {
+		net, ok := obj.(*Net)
+		if !ok {
+			return nil, fmt.Errorf("deserialize ensemble: unexpected type %T", obj)
+		}
+		res.Nets = append(res.Nets, net)
+	}
+	if len(res.Nets) == 0 {
+		return nil, errors.New("deserialize ensemble: no nets")
+	}
+	if len(res.Weights) == 0 {
+		res.Weights = nil
+	} else if len(res.Weights) != len(res.Nets) {
+		return nil, fmt.Errorf("deserialize ensemble: %d weights for %d nets",
+			len(res.Weights), len(res.Nets))
+	}
+	for _, w := range res.Weights {
+		if !(w > 0) || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("deserialize ensemble: invalid weight %f", w)
+		}
+	}
+	return &res, nil
+}
+
+// Evaluate generates a prediction for an image.
+func (e *Ensemble) Evaluate(img image.Image) (angle, confidence float64) {
+	angles, confidences := e.EvaluateBatch([]image.Image{img})
+	return angles[0], confidences[0]
+}
+
+// EvaluateBatch generates predictions for a batch of
+// images.
+func (e *Ensemble) EvaluateBatch(imgs []image.Image) (angles, confidences []float64) {
+	angles, confidences, _ = e.EvaluateProbs(imgs)
+	return
+}
+
+// EvaluateProbs is like EvaluateBatch, but it also
+// produces the weighted average of the nets' right angle
+// probabilities (see Net.RightAngleProbs).
+func (e *Ensemble) EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+	probs [][4]float64) {
+	netAngles, netConfs, netProbs := e.evaluateNets(imgs)
+	probs = e.averageProbs(netProbs)
+	if e.Fusion == ProbabilityFusion {
+		angles, confidences = maxProbs(probs)
+	} else {
+		angles, confidences = e.fuse(netAngles, netConfs)
+	}
+	return
+}
+
+// EvaluateDisagreement is like EvaluateBatch, but it also
+// computes the Disagreement for each image using the same
+// forward passes.
+func (e *Ensemble) EvaluateDisagreement(imgs []image.Image) (angles, confidences,
+	disagreements []float64) {
+	netAngles, netConfs, netProbs := e.evaluateNets(imgs)
+	if e.Fusion == ProbabilityFusion {
+		angles, confidences = e.fuseProbs(netProbs)
+	} else {
+		angles, confidences = e.fuse(netAngles, netConfs)
+	}
+	return angles, confidences, e.disagreements(netProbs)
+}
+
+// evaluateNets runs each net on the images, where
+// netAngles[i][j] is the angle that net i predicted for
+// image j.
+func (e *Ensemble) evaluateNets(imgs []image.Image) (netAngles, netConfs [][]float64,
+	netProbs [][][4]float64) {
+	for _, net := range e.Nets {
+		a, c, p := net.EvaluateProbs(imgs)
+		netAngles = append(netAngles, a)
+		netConfs = append(netConfs, c)
+		netProbs = append(netProbs, p)
+	}
+	return
+}
+
+// EvaluateTTA applies test-time augmentation to each of
+// the nets and fuses the results.
+//
+// With ProbabilityFusion, the aggregated prediction from
+// each net is converted into right angle probabilities in
+// the same way as for nets without probability outputs.
+func (e *Ensemble) EvaluateTTA(imgs []image.Image, t *TTA) (angles, confidences []float64) {
+	var netAngles, netConfs [][]float64
+	for _, net := range e.Nets {
+		a, c := net.EvaluateTTA(imgs, t)
+		netAngles = append(netAngles, a)
+		netConfs = append(netConfs, c)
+	}
+	return e.fuse(netAngles, netConfs)
+}
+
+// SerializerType returns the unique ID used to serialize
+// an Ensemble with the serializer package.
+func (e *Ensemble) SerializerType() string {
+	return "github.com/unixpickle/autorot.Ensemble"
+}
+
+// Serialize serializes the Ensemble.
+func (e *Ensemble) Serialize() ([]byte, error) {
+	var nets []serializer.Serializer
+	for _, net := range e.Nets {
+		nets = append(nets, net)
+	}
+	netData, err := serializer.SerializeSlice(nets)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.SerializeAny(
+		serializer.Int(e.Fusion),
+		serializer.Float64Slice(e.Weights),
+		serializer.Bytes(netData),
+	)
+}
+
+func (e *Ensemble) weight(netIdx int) float64 {
+	if e.Weights == nil {
+		return 1
+	}
+	return e.Weights[netIdx]
+}
+
+// fuse combines per-net predictions, where netAngles[i][j]
+// is the angle that net i predicted for image j.
+func (e *Ensemble) fuse(netAngles, netConfs [][]float64) (angles, confidences []float64) {
+	if e.Fusion == ProbabilityFusion {
+		var probs [][][4]float64
+		for i, net := range e.Nets {
+			probs = append(probs, anglesToProbs(netAngles[i], netConfs[i],
+				net.OutputType != RawAngle))
+		}
+		return e.fuseProbs(probs)
+	}
+	for imgIdx := range netAngles[0] {
+		votes := make([]vote, len(e.Nets))
+		for i, net := range e.Nets {
+			votes[i] = vote{
+				Angle:         netAngles[i][imgIdx],
+				Confidence:    netConfs[i][imgIdx],
+				Weight:        e.weight(i),
+				HasConfidence: net.OutputType != RawAngle,
+			}
+		}
+		angle, confidence := circularMean(votes)
+		angles = append(angles, angle)
+		confidences = append(confidences, confidence)
+	}
+	return
+}
+
+// Disagreement measures how much the nets disagree about
+// each image.
+//
+// It computes the mutual information (in nats) between the
+// predicted right angle and the choice of net, i.e. the
+// entropy of the averaged distribution minus the average
+// entropy of the nets' distributions.
+// Unlike a low confidence, a high disagreement indicates
+// that the nets are each confident about different angles.
+func (e *Ensemble) Disagreement(imgs []image.Image) []float64 {
+	var netProbs [][][4]float64
+	for _, net := range e.Nets {
+		netProbs = append(netProbs, net.RightAngleProbs(imgs))
+	}
+	return e.disagreements(netProbs)
+}
+
+// disagreements computes the mutual information for each
+// image from per-net right angle probabilities.
+func (e *Ensemble) disagreements(netProbs [][][4]float64) []float64 {
+	res := make([]float64, len(netProbs[0]))
+	for imgIdx := range res {
+		dists := make([][4]float64, len(e.Nets))
+		for i := range e.Nets {
+			dists[i] = netProbs[i][imgIdx]
+		}
+		res[imgIdx] = e.mutualInformation(dists)
+	}
+	return res
+}
+
+func (e *Ensemble) mutualInformation(dists [][4]float64) float64 {
+	var mean [4]float64
+	var meanEntropy, totalWeight float64
+	for i, dist := range dists {
+		for j, p := range dist {
+			mean[j] += e.weight(i) * p
+		}
+		meanEntropy += e.weight(i) * entropy(dist)
+		totalWeight += e.weight(i)
+	}
+	for j := range mean {
+		mean[j] /= totalWeight
+	}
+	return math.Max(0, entropy(mean)-meanEntropy/totalWeight)
+}
+
+func entropy(dist [4]float64) float64 {
+	var res float64
+	for _, p := range dist {
+		if p > 0 {
+			res -= p * math.Log(p)
+		}
+	}
+	return res
+}
+
+// fuseProbs combines per-net right angle probabilities,
+// where probs[i][j] comes from net i for image j.
+func (e *Ensemble) fuseProbs(probs [][][4]float64) (angles, confidences []float64) {
+	return maxProbs(e.averageProbs(probs))
+}
+
+// averageProbs computes the weighted average of per-net
+// right angle probabilities for each image.
+func (e *Ensemble) averageProbs(probs [][][4]float64) [][4]float64 {
+	res := make([][4]float64, len(probs[0]))
+	for imgIdx := range res {
+		var totalWeight float64
+		for i := range e.Nets {
+			for j, p := range probs[i][imgIdx] {
+				res[imgIdx][j] += e.weight(i) * p
+			}
+			totalWeight += e.weight(i)
+		}
+		for j := range res[imgIdx] {
+			res[imgIdx][j] /= totalWeight
+		}
+	}
+	return res
+}
+
+// maxProbs picks the most likely right angle for each
+// probability distribution.
+func maxProbs(probs [][4]float64) (angles, confidences []float64) {
+	for _, dist := range probs {
+		var maxIdx int
+		for j, p := range dist {
+			if p > dist[maxIdx] {
+				maxIdx = j
+			}
+		}
+		angles = append(angles, float64(maxIdx)*math.Pi/2)
+		confidences = append(confidences, dist[maxIdx])
+	}
+	return
+}
+
+// anglesToProbs converts predictions into right angle
+// probabilities by giving the nearest right angle the
+// prediction's confidence.
+// If hasConfidence is false, predictions are treated as
+// certain.
+func anglesToProbs(angles, confidences []float64, hasConfidence bool) [][4]float64 {
+	res := make([][4]float64, len(angles))
+	for i, angle := range angles {
+		confidence := 1.0
+		if hasConfidence {
+			confidence = confidences[i]
+		}
+		for j := range res[i] {
+			res[i][j] = (1 - confidence) / 3
+		}
+		res[i][NearestRightAngle(angle)] = confidence
+	}
+	return res
+}
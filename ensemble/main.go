@@ -0,0 +1,75 @@
+// Command ensemble combines multiple autorot networks into
+// a single ensemble.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	var outFile string
+	var fusion string
+	flag.StringVar(&outFile, "out", "", "output ensemble path")
+	flag.StringVar(&fusion, "fusion", "circular", "fusion method (circular or prob)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ensemble [flags] <net[:weight]> ...")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if outFile == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ensemble := &autorot.Ensemble{}
+	switch fusion {
+	case "circular":
+		ensemble.Fusion = autorot.CircularFusion
+	case "prob":
+		ensemble.Fusion = autorot.ProbabilityFusion
+	default:
+		essentials.Die("Unknown fusion method:", fusion)
+	}
+
+	var weighted bool
+	for _, arg := range flag.Args() {
+		path := arg
+		weight := 1.0
+		if idx := strings.LastIndex(arg, ":"); idx >= 0 {
+			var err error
+			weight, err = strconv.ParseFloat(arg[idx+1:], 64)
+			if err != nil {
+				essentials.Die("Invalid weight in", arg+":", err)
+			}
+			if !(weight > 0) || math.IsInf(weight, 0) {
+				essentials.Die("Weights must be positive:", arg)
+			}
+			path = arg[:idx]
+			weighted = true
+		}
+		var net *autorot.Net
+		if err := serializer.LoadAny(path, &net); err != nil {
+			essentials.Die("Load network failed:", err)
+		}
+		ensemble.Nets = append(ensemble.Nets, net)
+		ensemble.Weights = append(ensemble.Weights, weight)
+	}
+	if !weighted {
+		ensemble.Weights = nil
+	}
+
+	if err := serializer.SaveAny(outFile, ensemble); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+}
@@ -0,0 +1,107 @@
+package autorot
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestEnsembleSingleNet(t *testing.T) {
+	imgs := []image.Image{randomImage(6, 6), randomImage(8, 5)}
+	for _, outType := range []OutputType{RightAngles, ConfidenceAngle} {
+		net := testNet(6, outType)
+		expected, expectedConfs := net.EvaluateBatch(imgs)
+		for _, fusion := range []Fusion{CircularFusion, ProbabilityFusion} {
+			if fusion == ProbabilityFusion && outType != RightAngles {
+				continue
+			}
+			ensemble := &Ensemble{Nets: []*Net{net, net}, Fusion: fusion}
+			actual, actualConfs := ensemble.EvaluateBatch(imgs)
+			for i, x := range expected {
+				if AngleDiff(x, actual[i]) > 1e-4 {
+					t.Errorf("type %d fusion %d: expected angle %f but got %f",
+						outType, fusion, x, actual[i])
+				}
+				if math.Abs(expectedConfs[i]-actualConfs[i]) > 1e-4 {
+					t.Errorf("type %d fusion %d: expected confidence %f but got %f",
+						outType, fusion, expectedConfs[i], actualConfs[i])
+				}
+			}
+		}
+	}
+}
+
+func TestEnsembleFuseProbs(t *testing.T) {
+	ensemble := &Ensemble{
+		Nets:    []*Net{{}, {}},
+		Weights: []float64{1, 3},
+		Fusion:  ProbabilityFusion,
+	}
+	probs := [][][4]float64{
+		{{0.7, 0.1, 0.1, 0.1}},
+		{{0.1, 0.5, 0.2, 0.2}},
+	}
+	angles, confidences := ensemble.fuseProbs(probs)
+	if angles[0] != math.Pi/2 {
+		t.Errorf("expected angle %f but got %f", math.Pi/2, angles[0])
+	}
+	if math.Abs(confidences[0]-0.4) > 1e-8 {
+		t.Errorf("expected confidence 0.4 but got %f", confidences[0])
+	}
+}
+
+func TestEnsembleMutualInformation(t *testing.T) {
+	ensemble := &Ensemble{Nets: []*Net{{}, {}}}
+	agree := ensemble.mutualInformation([][4]float64{
+		{0.7, 0.1, 0.1, 0.1},
+		{0.7, 0.1, 0.1, 0.1},
+	})
+	if math.Abs(agree) > 1e-8 {
+		t.Errorf("expected no disagreement but got %f", agree)
+	}
+	disagree := ensemble.mutualInformation([][4]float64{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+	})
+	if math.Abs(disagree-math.Log(2)) > 1e-8 {
+		t.Errorf("expected disagreement %f but got %f", math.Log(2), disagree)
+	}
+}
+
+func TestDeserializeInvalidEnsemble(t *testing.T) {
+	net := testNet(4, RightAngles)
+	for _, ensemble := range []*Ensemble{
+		{},
+		{Nets: []*Net{net}, Weights: []float64{1, 2}},
+		{Nets: []*Net{net, net}, Weights: []float64{0, 0}},
+		{Nets: []*Net{net, net}, Weights: []float64{2, -1}},
+	} {
+		data, err := ensemble.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DeserializeEnsemble(data); err == nil {
+			t.Errorf("expected error for %d nets and weights %v", len(ensemble.Nets),
+				ensemble.Weights)
+		}
+	}
+}
+
+func TestEnsembleEvaluateDisagreement(t *testing.T) {
+	imgs := []image.Image{randomImage(6, 6), randomImage(8, 5)}
+	nets := []*Net{testNet(6, RightAngles), testNet(6, RightAngles)}
+	for _, fusion := range []Fusion{CircularFusion, ProbabilityFusion} {
+		ensemble := &Ensemble{Nets: nets, Fusion: fusion}
+		expected, expectedConfs := ensemble.EvaluateBatch(imgs)
+		expectedDis := ensemble.Disagreement(imgs)
+		actual, actualConfs, actualDis := ensemble.EvaluateDisagreement(imgs)
+		for i := range imgs {
+			if actual[i] != expected[i] || actualConfs[i] != expectedConfs[i] ||
+				actualDis[i] != expectedDis[i] {
+				t.Errorf("fusion %d image %d: expected (%f, %f, %f) but got (%f, %f, %f)",
+					fusion, i, expected[i], expectedConfs[i], expectedDis[i], actual[i],
+					actualConfs[i], actualDis[i])
+			}
+		}
+	}
+}
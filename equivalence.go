@@ -0,0 +1,82 @@
+package autorot
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// An OutputDeviation measures how much the outputs of two
+// networks differ on the same images.
+type OutputDeviation struct {
+	// MaxDeviation and MeanDeviation are the largest and
+	// average absolute differences between corresponding
+	// network outputs.
+	// For RightAngles nets, the outputs are compared as
+	// probabilities rather than log probabilities.
+	MaxDeviation  float64
+	MeanDeviation float64
+
+	// Agreement is the fraction of images for which both
+	// networks predict the same right angle.
+	Agreement float64
+}
+
+// CompareOutputs evaluates two networks with the same
+// configuration on the images and measures how much their
+// outputs differ.
+// Calibration is not applied to the outputs.
+//
+// The images are evaluated in batches of batchSize, or all
+// at once if batchSize is 0.
+// Networks with BatchNorm layers use the statistics of
+// each batch, so batches should not be too small.
+func CompareOutputs(n1, n2 *Net, imgs []image.Image, batchSize int) (*OutputDeviation,
+	error) {
+	if n1.InputSize != n2.InputSize || n1.OutputType != n2.OutputType ||
+		n1.InputMode != n2.InputMode {
+		return nil, errors.New("compare outputs: mismatching network configurations")
+	}
+	if len(imgs) == 0 {
+		return nil, errors.New("compare outputs: no images")
+	}
+	if batchSize <= 0 {
+		batchSize = len(imgs)
+	}
+
+	res := &OutputDeviation{}
+	var numOutputs int
+	var agree int
+	for i := 0; i < len(imgs); i += batchSize {
+		batch := imgs[i:]
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		out1 := n1.applyBatch(n1.fitInputs(batch))
+		out2 := n2.applyBatch(n2.fitInputs(batch))
+		data1 := out1.Data().([]float32)
+		data2 := out2.Data().([]float32)
+		for j, x := range data1 {
+			a, b := float64(x), float64(data2[j])
+			if n1.OutputType == RightAngles {
+				a, b = math.Exp(a), math.Exp(b)
+			}
+			diff := math.Abs(a - b)
+			res.MaxDeviation = math.Max(res.MaxDeviation, diff)
+			res.MeanDeviation += diff
+		}
+		numOutputs += len(data1)
+
+		angles1, _ := n1.decodeOutputs(out1, len(batch))
+		angles2, _ := n2.decodeOutputs(out2, len(batch))
+		for j, angle := range angles1 {
+			if NearestRightAngle(angle) == NearestRightAngle(angles2[j]) {
+				agree++
+			}
+		}
+	}
+	res.MeanDeviation /= float64(numOutputs)
+	res.Agreement = float64(agree) / float64(len(imgs))
+
+	return res, nil
+}
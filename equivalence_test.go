@@ -0,0 +1,59 @@
+package autorot
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/unixpickle/anynet"
+)
+
+func TestCompareOutputs(t *testing.T) {
+	imgs := []image.Image{randomImage(6, 6), randomImage(8, 7), randomImage(6, 9)}
+	for _, outType := range []OutputType{RawAngle, RightAngles, ConfidenceAngle} {
+		net := testNet(6, outType)
+		clone, err := CloneEvaluator(net)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := clone.(*Net)
+
+		dev, err := CompareOutputs(net, other, imgs, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev.MaxDeviation != 0 || dev.MeanDeviation != 0 || dev.Agreement != 1 {
+			t.Errorf("type %s: identical nets deviate: %+v", outType, dev)
+		}
+
+		biases := other.Net[2].(*anynet.FC).Biases.Vector
+		biasData := biases.Data().([]float32)
+		biasData[0] += 0.5
+		biases.SetData(biasData)
+		dev, err = CompareOutputs(net, other, imgs, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunked, err := CompareOutputs(net, other, imgs, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(chunked.MaxDeviation-dev.MaxDeviation) > 1e-5 ||
+			math.Abs(chunked.MeanDeviation-dev.MeanDeviation) > 1e-5 ||
+			chunked.Agreement != dev.Agreement {
+			t.Errorf("type %s: chunked comparison gave %+v but expected %+v", outType,
+				chunked, dev)
+		}
+		if dev.MaxDeviation == 0 || dev.MeanDeviation > dev.MaxDeviation {
+			t.Errorf("type %s: unexpected deviation: %+v", outType, dev)
+		}
+		if outType != RightAngles && math.Abs(dev.MaxDeviation-0.5) > 1e-4 {
+			t.Errorf("expected deviation 0.5 but got %f", dev.MaxDeviation)
+		}
+
+		other.OutputType = (outType + 1) % 3
+		if _, err := CompareOutputs(net, other, imgs, 0); err == nil {
+			t.Errorf("type %s: expected configuration error", outType)
+		}
+	}
+}
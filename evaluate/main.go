@@ -0,0 +1,131 @@
+// Command evaluate measures the accuracy of an autorot
+// network on a directory of upright images.
+//
+// Each image is rotated by known angles (either the four
+// right angles or a sweep of continuous angles) and the
+// predictions are compared to the true rotations.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	var netPath string
+	var dataDir string
+	var sweepStep float64
+	var batchSize int
+	var numWorst int
+	var jsonPath string
+
+	flag.StringVar(&netPath, "net", "", "network or ensemble path")
+	flag.StringVar(&dataDir, "data", "", "directory of upright images")
+	flag.Float64Var(&sweepStep, "sweep", 0,
+		"step in degrees for a continuous sweep (0 for right angles only)")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size")
+	flag.IntVar(&numWorst, "worst", 10, "number of worst offenders to report")
+	flag.StringVar(&jsonPath, "json", "", "optional path for a JSON report")
+	flag.Parse()
+
+	if netPath == "" || dataDir == "" {
+		essentials.Die("Required flags: -net and -data. See -help for more.")
+	}
+	if sweepStep < 0 {
+		essentials.Die("Sweep step must not be negative.")
+	}
+
+	model, err := autorot.LoadEvaluator(netPath)
+	if err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	listing, err := autorot.ReadSampleList(0, dataDir)
+	if err != nil {
+		essentials.Die("Load data failed:", err)
+	}
+
+	var angles []float64
+	if sweepStep == 0 {
+		angles = []float64{0, math.Pi / 2, math.Pi, 3 * math.Pi / 2}
+	} else {
+		for deg := 0.0; deg < 360; deg += sweepStep {
+			angles = append(angles, deg*math.Pi/180)
+		}
+	}
+
+	log.Println("Evaluating", listing.Len(), "images at", len(angles), "angles...")
+	var results []Result
+	for _, path := range listing.Paths {
+		img, _, err := autorot.ReadImage(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		for i := 0; i < len(angles); i += batchSize {
+			end := i + batchSize
+			if end > len(angles) {
+				end = len(angles)
+			}
+			results = append(results, evaluateRotations(model, path, img, angles[i:end],
+				sweepStep == 0)...)
+		}
+	}
+
+	report := NewReport(results, numWorst)
+	report.WriteText(os.Stdout)
+
+	if jsonPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			essentials.Die("Encode report failed:", err)
+		}
+		if err := ioutil.WriteFile(jsonPath, data, 0644); err != nil {
+			essentials.Die("Write report failed:", err)
+		}
+	}
+}
+
+// evaluateRotations evaluates rotated copies of an image.
+//
+// If rightAngles is set, the angles must be right angles
+// and the whole image is rotated without resampling.
+// Otherwise, every rotation (including 0) is cropped to a
+// square so that all angles are evaluated consistently.
+func evaluateRotations(model autorot.Evaluator, path string, img image.Image,
+	angles []float64, rightAngles bool) []Result {
+	side := img.Bounds().Dx()
+	if img.Bounds().Dy() < side {
+		side = img.Bounds().Dy()
+	}
+	views := make([]image.Image, len(angles))
+	for i, angle := range angles {
+		if rightAngles {
+			views[i] = autorot.RotateRightAngle(img, autorot.NearestRightAngle(angle))
+		} else {
+			views[i] = autorot.Rotate(img, angle, side)
+		}
+	}
+	predictions, confidences := model.EvaluateBatch(views)
+	results := make([]Result, len(angles))
+	for i, angle := range angles {
+		results[i] = Result{
+			Path:       path,
+			TrueAngle:  angle,
+			Angle:      predictions[i],
+			Confidence: confidences[i],
+			Error:      autorot.AngleDiff(predictions[i], angle) * 180 / math.Pi,
+		}
+	}
+	return results
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/unixpickle/autorot"
+)
+
+// A Result is the prediction for one rotated image.
+type Result struct {
+	Path       string  `json:"path"`
+	TrueAngle  float64 `json:"true_angle"`
+	Angle      float64 `json:"angle"`
+	Confidence float64 `json:"confidence"`
+
+	// Error is the absolute angular error in degrees.
+	Error float64 `json:"error"`
+}
+
+// A CoveragePoint gives the accuracy of the most confident
+// fraction of the predictions.
+type CoveragePoint struct {
+	Coverage  float64 `json:"coverage"`
+	Threshold float64 `json:"threshold"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// A Report summarizes the accuracy of a network.
+type Report struct {
+	Count int `json:"count"`
+
+	// Confusion[i][j] counts the images rotated by i right
+	// angles which were predicted to be rotated by j.
+	Confusion [4][4]int `json:"confusion"`
+
+	MeanError   float64 `json:"mean_error"`
+	MedianError float64 `json:"median_error"`
+
+	// Accuracy maps tolerances in degrees to the fraction
+	// of predictions within the tolerance.
+	Accuracy map[string]float64 `json:"accuracy"`
+
+	Coverage []CoveragePoint `json:"coverage"`
+
+	// WorstOffenders contains the worst rotated view of
+	// each of the images with the largest errors.
+	WorstOffenders []Result `json:"worst_offenders"`
+}
+
+var tolerances = []float64{1, 5, 45}
+
+// NewReport computes a report from a set of results.
+func NewReport(results []Result, numWorst int) *Report {
+	r := &Report{
+		Count:    len(results),
+		Accuracy: map[string]float64{},
+	}
+	if len(results) == 0 {
+		return r
+	}
+
+	errs := make([]float64, len(results))
+	for i, res := range results {
+		trueIdx := autorot.NearestRightAngle(res.TrueAngle)
+		r.Confusion[trueIdx][autorot.NearestRightAngle(res.Angle)]++
+		errs[i] = res.Error
+		r.MeanError += res.Error / float64(len(results))
+	}
+	sort.Float64s(errs)
+	if len(errs)%2 == 1 {
+		r.MedianError = errs[len(errs)/2]
+	} else {
+		r.MedianError = (errs[len(errs)/2-1] + errs[len(errs)/2]) / 2
+	}
+	for _, tol := range tolerances {
+		var count int
+		for _, e := range errs {
+			if e <= tol {
+				count++
+			}
+		}
+		r.Accuracy[toleranceKey(tol)] = float64(count) / float64(len(errs))
+	}
+
+	byConf := append([]Result{}, results...)
+	sort.SliceStable(byConf, func(i, j int) bool {
+		return byConf[i].Confidence > byConf[j].Confidence
+	})
+	for i := 1; i <= 10; i++ {
+		count := int(math.Ceil(float64(len(byConf)) * float64(i) / 10))
+		var correct int
+		for _, res := range byConf[:count] {
+			if res.Error < 45 {
+				correct++
+			}
+		}
+		r.Coverage = append(r.Coverage, CoveragePoint{
+			Coverage:  float64(i) / 10,
+			Threshold: byConf[count-1].Confidence,
+			Accuracy:  float64(correct) / float64(count),
+		})
+	}
+
+	byErr := append([]Result{}, results...)
+	sort.SliceStable(byErr, func(i, j int) bool {
+		if byErr[i].Error == byErr[j].Error {
+			return byErr[i].Confidence > byErr[j].Confidence
+		}
+		return byErr[i].Error > byErr[j].Error
+	})
+	seen := map[string]bool{}
+	for _, res := range byErr {
+		if len(r.WorstOffenders) == numWorst {
+			break
+		}
+		if !seen[res.Path] {
+			seen[res.Path] = true
+			r.WorstOffenders = append(r.WorstOffenders, res)
+		}
+	}
+
+	return r
+}
+
+// WriteText writes a human-readable version of the report.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Evaluated %d rotated images.\n\n", r.Count)
+
+	fmt.Fprintln(w, "Right angle confusion matrix (rows: actual, columns: predicted):")
+	fmt.Fprintf(w, "%8s", "")
+	for j := 0; j < 4; j++ {
+		fmt.Fprintf(w, "%8d", j*90)
+	}
+	fmt.Fprintln(w)
+	for i, row := range r.Confusion {
+		fmt.Fprintf(w, "%8d", i*90)
+		for _, count := range row {
+			fmt.Fprintf(w, "%8d", count)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Mean absolute error:   %.3f deg\n", r.MeanError)
+	fmt.Fprintf(w, "Median absolute error: %.3f deg\n", r.MedianError)
+	for _, tol := range tolerances {
+		key := toleranceKey(tol)
+		fmt.Fprintf(w, "Accuracy at %-4s       %.2f%%\n", key+":", r.Accuracy[key]*100)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Accuracy (within 45 deg) vs. coverage:")
+	for _, p := range r.Coverage {
+		fmt.Fprintf(w, "  %3.0f%% (confidence >= %.4f): %.2f%%\n", p.Coverage*100,
+			p.Threshold, p.Accuracy*100)
+	}
+
+	if len(r.WorstOffenders) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Worst offenders:")
+		for _, res := range r.WorstOffenders {
+			fmt.Fprintf(w, "  %s (rotated %.1f deg): predicted %.1f deg, confidence %.4f, "+
+				"error %.1f deg\n", res.Path, res.TrueAngle*180/math.Pi,
+				res.Angle*180/math.Pi, res.Confidence, res.Error)
+		}
+	}
+}
+
+func toleranceKey(tol float64) string {
+	return fmt.Sprintf("±%g", tol)
+}
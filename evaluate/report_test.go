@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewReport(t *testing.T) {
+	results := []Result{
+		{Path: "a", TrueAngle: 0, Angle: 0, Confidence: 0.9, Error: 0},
+		{Path: "a", TrueAngle: math.Pi / 2, Angle: math.Pi/2 + 0.03, Confidence: 0.8,
+			Error: 2},
+		{Path: "b", TrueAngle: 0, Angle: math.Pi, Confidence: 0.7, Error: 180},
+		{Path: "b", TrueAngle: math.Pi, Angle: 0, Confidence: 0.6, Error: 180},
+		{Path: "c", TrueAngle: 3 * math.Pi / 2, Angle: 3 * math.Pi / 2, Confidence: 0.5,
+			Error: 0.5},
+		{Path: "c", TrueAngle: math.Pi, Angle: 4 * math.Pi / 3, Confidence: 0.4, Error: 60},
+	}
+	r := NewReport(results, 2)
+
+	if r.Count != 6 {
+		t.Errorf("expected count 6 but got %d", r.Count)
+	}
+	var confusion [4][4]int
+	confusion[0][0] = 1
+	confusion[1][1] = 1
+	confusion[0][2] = 1
+	confusion[2][0] = 1
+	confusion[3][3] = 1
+	confusion[2][3] = 1
+	if r.Confusion != confusion {
+		t.Errorf("expected confusion %v but got %v", confusion, r.Confusion)
+	}
+
+	tests := []struct {
+		Name     string
+		Expected float64
+		Actual   float64
+	}{
+		{"mean", 422.5 / 6, r.MeanError},
+		{"median", 31, r.MedianError},
+		{"±1", 2.0 / 6, r.Accuracy["±1"]},
+		{"±5", 3.0 / 6, r.Accuracy["±5"]},
+		{"±45", 3.0 / 6, r.Accuracy["±45"]},
+		{"coverage 10%", 1, r.Coverage[0].Accuracy},
+		{"threshold 10%", 0.9, r.Coverage[0].Threshold},
+		{"coverage 50%", 2.0 / 3, r.Coverage[4].Accuracy},
+		{"threshold 50%", 0.7, r.Coverage[4].Threshold},
+		{"coverage 100%", 0.5, r.Coverage[9].Accuracy},
+		{"threshold 100%", 0.4, r.Coverage[9].Threshold},
+	}
+	for _, test := range tests {
+		if math.Abs(test.Expected-test.Actual) > 1e-8 {
+			t.Errorf("%s: expected %f but got %f", test.Name, test.Expected, test.Actual)
+		}
+	}
+	if len(r.Coverage) != 10 {
+		t.Errorf("expected 10 coverage points but got %d", len(r.Coverage))
+	}
+
+	if len(r.WorstOffenders) != 2 {
+		t.Fatalf("expected 2 worst offenders but got %d", len(r.WorstOffenders))
+	}
+	if w := r.WorstOffenders[0]; w.Path != "b" || w.Confidence != 0.7 {
+		t.Errorf("unexpected first offender: %+v", w)
+	}
+	if w := r.WorstOffenders[1]; w.Path != "c" || w.Error != 60 {
+		t.Errorf("unexpected second offender: %+v", w)
+	}
+}
+
+func TestNewReportEmpty(t *testing.T) {
+	r := NewReport(nil, 5)
+	if r.Count != 0 || len(r.WorstOffenders) != 0 || len(r.Coverage) != 0 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+}
@@ -0,0 +1,156 @@
+package autorot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	jpegSOI  = 0xd8
+	jpegAPP1 = 0xe1
+	jpegSOS  = 0xda
+
+	exifOrientationTag = 0x0112
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// JPEGExif finds the EXIF segment in JPEG data.
+//
+// It returns the segment payload (starting with the
+// "Exif\x00\x00" header) along with the offset of the
+// payload within data.
+// If there is no EXIF segment, the payload is nil.
+func JPEGExif(data []byte) (payload []byte, offset int) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, 0
+	}
+	idx := 2
+	for idx+4 <= len(data) {
+		if data[idx] != 0xff {
+			return nil, 0
+		}
+		marker := data[idx+1]
+		if marker == jpegSOS {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[idx+2:]))
+		if size < 2 || idx+2+size > len(data) {
+			return nil, 0
+		}
+		segment := data[idx+4 : idx+2+size]
+		if marker == jpegAPP1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment, idx + 4
+		}
+		idx += 2 + size
+	}
+	return nil, 0
+}
+
+// InsertJPEGExif adds an EXIF payload (as returned by
+// JPEGExif) to JPEG data which has no EXIF segment.
+func InsertJPEGExif(data, payload []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, errors.New("insert EXIF: not a JPEG")
+	}
+	if len(payload)+2 > 0xffff {
+		return nil, errors.New("insert EXIF: payload too large")
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	buf.Write([]byte{0xff, jpegAPP1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(payload)+2))
+	buf.Write(payload)
+	buf.Write(data[2:])
+	return buf.Bytes(), nil
+}
+
+// ExifOrientation reads the orientation tag from an EXIF
+// payload.
+// The second return value is false if there is no tag.
+func ExifOrientation(payload []byte) (int, bool) {
+	offset, order, err := exifOrientationOffset(payload)
+	if err != nil {
+		return 0, false
+	}
+	return int(order.Uint16(payload[offset:])), true
+}
+
+// SetExifOrientation overwrites the orientation tag of an
+// EXIF payload in place.
+// Since the payload does not change size, the same can be
+// done to a payload inside a JPEG file.
+//
+// An error is returned if the payload has no orientation
+// tag.
+func SetExifOrientation(payload []byte, orientation int) error {
+	offset, order, err := exifOrientationOffset(payload)
+	if err != nil {
+		return err
+	}
+	order.PutUint16(payload[offset:], uint16(orientation))
+	return nil
+}
+
+// OrientationForTurns returns the EXIF orientation which
+// instructs viewers to rotate an image clockwise by the
+// given number of quarter turns.
+func OrientationForTurns(turns int) int {
+	return []int{1, 6, 3, 8}[((turns%4)+4)%4]
+}
+
+// TurnsForOrientation is the inverse of
+// OrientationForTurns.
+// The second return value is false for orientations that
+// involve mirroring.
+func TurnsForOrientation(orientation int) (int, bool) {
+	switch orientation {
+	case 1:
+		return 0, true
+	case 6:
+		return 1, true
+	case 3:
+		return 2, true
+	case 8:
+		return 3, true
+	default:
+		return 0, false
+	}
+}
+
+// exifOrientationOffset finds the offset of the
+// orientation value within an EXIF payload.
+func exifOrientationOffset(payload []byte) (int, binary.ByteOrder, error) {
+	if !bytes.HasPrefix(payload, exifHeader) {
+		return 0, nil, errors.New("EXIF: missing header")
+	}
+	tiff := payload[len(exifHeader):]
+	if len(tiff) < 8 {
+		return 0, nil, errors.New("EXIF: truncated TIFF header")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, nil, errors.New("EXIF: bad byte order")
+	}
+	ifdOffset := int(order.Uint32(tiff[4:]))
+	if ifdOffset+2 > len(tiff) {
+		return 0, nil, errors.New("EXIF: truncated IFD")
+	}
+	numEntries := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < numEntries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, nil, errors.New("EXIF: truncated IFD")
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			return len(exifHeader) + entry + 8, order, nil
+		}
+	}
+	return 0, nil, errors.New("EXIF: no orientation tag")
+}
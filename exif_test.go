@@ -0,0 +1,141 @@
+package autorot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+func TestJPEGExif(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, randomImage(5, 3), nil); err != nil {
+		t.Fatal(err)
+	}
+	if payload, _ := JPEGExif(buf.Bytes()); payload != nil {
+		t.Fatal("unexpected EXIF payload")
+	}
+	data, err := InsertJPEGExif(buf.Bytes(), testExifPayload(binary.BigEndian, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, offset := JPEGExif(data)
+	if payload == nil {
+		t.Fatal("missing EXIF payload")
+	}
+	if orientation, ok := ExifOrientation(payload); !ok || orientation != 6 {
+		t.Fatalf("expected orientation 6 but got %d (%v)", orientation, ok)
+	}
+	if err := SetExifOrientation(data[offset:offset+len(payload)], 8); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ = JPEGExif(data)
+	if orientation, _ := ExifOrientation(payload); orientation != 8 {
+		t.Errorf("expected orientation 8 but got %d", orientation)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("decode modified JPEG: %v", err)
+	}
+}
+
+func TestEncodeImageExif(t *testing.T) {
+	exif := testExifPayload(binary.LittleEndian, 3)
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, randomImage(4, 4), "jpeg", 90, exif); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := JPEGExif(buf.Bytes())
+	if orientation, ok := ExifOrientation(payload); !ok || orientation != 1 {
+		t.Errorf("expected orientation 1 but got %d (%v)", orientation, ok)
+	}
+	if orientation, _ := ExifOrientation(exif); orientation != 3 {
+		t.Error("original payload was modified")
+	}
+}
+
+func TestCorrectFile(t *testing.T) {
+	img := randomImage(5, 3)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	tagged, err := InsertJPEGExif(plain, testExifPayload(binary.BigEndian, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A net predicting 90 degrees calls for a 270 degree
+	// correction.
+	angle := math.Pi / 2
+	c, err := CorrectFile(tagged, img, angle, AutoMode, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Transform != ExifTransform || c.OldOrientation != 1 || c.NewOrientation != 8 {
+		t.Errorf("unexpected EXIF correction: %s %d %d", c.Transform, c.OldOrientation,
+			c.NewOrientation)
+	}
+	if len(c.Data) != len(tagged) {
+		t.Error("EXIF correction changed the file size")
+	}
+
+	if _, err := CorrectFile(plain, img, angle, ExifMode, 90); err == nil {
+		t.Error("expected error for EXIF mode without a tag")
+	}
+	c, err = CorrectFile(plain, img, angle, AutoMode, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Transform != RewriteTransform {
+		t.Errorf("unexpected transform: %s", c.Transform)
+	}
+	decoded, format, err := image.Decode(bytes.NewReader(c.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || decoded.Bounds().Dx() != 3 || decoded.Bounds().Dy() != 5 {
+		t.Errorf("unexpected rewrite: %s %v", format, decoded.Bounds())
+	}
+
+	for _, mode := range []string{AutoMode, ExifMode, RewriteMode} {
+		if c, err := CorrectFile(plain, img, 0, mode, 90); err != nil || c != nil {
+			t.Errorf("mode %s: expected no correction but got %v (%v)", mode, c, err)
+		}
+	}
+}
+
+func TestOrientationTurns(t *testing.T) {
+	for turns := 0; turns < 4; turns++ {
+		actual, ok := TurnsForOrientation(OrientationForTurns(turns))
+		if !ok || actual != turns {
+			t.Errorf("turns %d: got %d (%v)", turns, actual, ok)
+		}
+	}
+	if _, ok := TurnsForOrientation(2); ok {
+		t.Error("mirrored orientation should not map to turns")
+	}
+}
+
+func testExifPayload(order binary.ByteOrder, orientation int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Exif\x00\x00")
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	binary.Write(&buf, order, uint16(1))
+	binary.Write(&buf, order, uint16(exifOrientationTag))
+	binary.Write(&buf, order, uint16(3))
+	binary.Write(&buf, order, uint32(1))
+	binary.Write(&buf, order, uint16(orientation))
+	binary.Write(&buf, order, uint16(0))
+	binary.Write(&buf, order, uint32(0))
+	return buf.Bytes()
+}
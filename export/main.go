@@ -0,0 +1,45 @@
+// Command export converts an autorot network into an ONNX
+// model, so that it can be run outside of Go.
+//
+// The model's metadata describes how to preprocess images
+// and how to decode the outputs.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/autorot/onnx"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	var inPath string
+	var outPath string
+	flag.StringVar(&inPath, "in", "", "input network (processed with post_train)")
+	flag.StringVar(&outPath, "out", "", "output ONNX file")
+	flag.Parse()
+
+	if inPath == "" || outPath == "" {
+		essentials.Die("Required flags: -in and -out. See -help for more.")
+	}
+
+	var net *autorot.Net
+	if err := serializer.LoadAny(inPath, &net); err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	model, err := onnx.Export(net)
+	if err != nil {
+		essentials.Die(err)
+	}
+	log.Printf("Exported %d nodes with %d initializers.", len(model.Graph.Nodes),
+		len(model.Graph.Initializers))
+	for _, key := range model.MetadataKeys {
+		log.Printf("%s: %s", key, model.Metadata[key])
+	}
+	if err := model.Save(outPath); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+}
@@ -0,0 +1,224 @@
+// Command fix corrects the rotation of images in place and
+// records every change in a journal, so that the changes
+// can be reverted with the undo command.
+//
+// When possible, JPEGs are fixed losslessly by changing
+// their EXIF orientation tag.
+// Other images are rewritten after the originals are
+// backed up.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+)
+
+// A Fixer applies corrections and journals them.
+type Fixer struct {
+	Dir       string
+	BackupDir string
+	Journal   *autorot.JournalWriter
+	ModelID   string
+
+	Threshold float64
+	Mode      string
+	Quality   int
+}
+
+func main() {
+	var fixer Fixer
+	var netPath string
+	var journalPath string
+	var batchSize int
+	flag.StringVar(&fixer.Dir, "dir", "", "image directory")
+	flag.StringVar(&netPath, "net", "", "network or ensemble path")
+	flag.StringVar(&journalPath, "journal", "", "journal path")
+	flag.StringVar(&fixer.BackupDir, "backup", "", "directory for backups of rewritten images")
+	flag.Float64Var(&fixer.Threshold, "threshold", 0.9, "minimum confidence for corrections")
+	flag.StringVar(&fixer.Mode, "mode", "auto",
+		"fix method (exif, rewrite, or auto to prefer exif)")
+	flag.IntVar(&fixer.Quality, "quality", 95, "JPEG quality for rewritten images")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size")
+	flag.Parse()
+
+	if fixer.Dir == "" || netPath == "" || journalPath == "" {
+		essentials.Die("Required flags: -dir, -net, and -journal. See -help for more.")
+	}
+	switch fixer.Mode {
+	case autorot.ExifMode:
+	case autorot.AutoMode, autorot.RewriteMode:
+		if fixer.BackupDir == "" {
+			essentials.Die("The -backup flag is required unless -mode is exif.")
+		}
+	default:
+		essentials.Die("Unknown mode:", fixer.Mode)
+	}
+
+	model, err := autorot.LoadEvaluator(netPath)
+	if err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	if fixer.Threshold > 0 && !autorot.HasConfidence(model) {
+		essentials.Die("The network does not produce confidences (RawAngle outputs), " +
+			"so -threshold must be 0.")
+	}
+	fixer.ModelID, err = autorot.HashFile(netPath)
+	if err != nil {
+		essentials.Die("Hash network failed:", err)
+	}
+	fixer.Journal, err = autorot.OpenJournal(journalPath)
+	if err != nil {
+		essentials.Die("Open journal failed:", err)
+	}
+	defer fixer.Journal.Close()
+
+	listing, err := autorot.ReadSampleList(0, fixer.Dir)
+	if err != nil {
+		essentials.Die("Directory listing failed:", err)
+	}
+	var numFixed int
+	for i := 0; i < len(listing.Paths); i += batchSize {
+		end := i + batchSize
+		if end > len(listing.Paths) {
+			end = len(listing.Paths)
+		}
+		numFixed += fixer.FixBatch(model, listing.Paths[i:end])
+	}
+	log.Printf("Fixed %d of %d images.", numFixed, len(listing.Paths))
+}
+
+// FixBatch evaluates and fixes a batch of images, returning
+// the number of images that were changed.
+func (f *Fixer) FixBatch(model autorot.Evaluator, paths []string) int {
+	var okPaths []string
+	var imgs []image.Image
+	for _, path := range paths {
+		img, _, err := autorot.ReadImage(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		okPaths = append(okPaths, path)
+		imgs = append(imgs, img)
+	}
+	angles, confidences := model.EvaluateBatch(imgs)
+	var numFixed int
+	for i, path := range okPaths {
+		if confidences[i] < f.Threshold || autorot.CorrectionTurns(angles[i]) == 0 {
+			continue
+		}
+		entry, err := f.Fix(path, imgs[i], angles[i], confidences[i])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		} else if entry != nil {
+			log.Printf("%s: %s (rotated %.0f deg, confidence %.3f)", path, entry.Transform,
+				float64(autorot.NearestRightAngle(angles[i]))*90, confidences[i])
+			numFixed++
+		}
+	}
+	return numFixed
+}
+
+// Fix applies a correction to a single image.
+// It returns nil if the image did not need to change.
+func (f *Fixer) Fix(path string, img image.Image, angle,
+	confidence float64) (*autorot.JournalEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("fix: " + err.Error())
+	}
+	entry := &autorot.JournalEntry{
+		Path:         path,
+		OriginalHash: autorot.HashBytes(data),
+		Angle:        angle,
+		Confidence:   confidence,
+		Model:        f.ModelID,
+		Time:         time.Now(),
+	}
+	correction, err := autorot.CorrectFile(data, img, angle, f.Mode, f.Quality)
+	if err != nil {
+		return nil, errors.New("fix " + path + ": " + err.Error())
+	} else if correction == nil {
+		return nil, nil
+	}
+	entry.Transform = correction.Transform
+	entry.OldOrientation = correction.OldOrientation
+	entry.NewOrientation = correction.NewOrientation
+
+	if correction.Transform == autorot.RewriteTransform {
+		rel, err := filepath.Rel(f.Dir, path)
+		if err != nil {
+			return nil, errors.New("fix: " + err.Error())
+		}
+		entry.Backup = filepath.Join(f.BackupDir, rel)
+		if _, err := os.Stat(entry.Backup); err == nil {
+			return nil, errors.New("fix " + path + ": backup already exists: " + entry.Backup)
+		}
+	}
+	if err := f.replace(path, data, correction.Data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// replace atomically overwrites a file and journals the
+// change.
+//
+// The journal entry is written before the backup (if any)
+// and before the file is renamed into place, so that every
+// change is undoable.
+// The backup is removed if the file cannot be replaced, so
+// that it does not block later fixes.
+func (f *Fixer) replace(path string, oldData, newData []byte,
+	entry *autorot.JournalEntry) error {
+	entry.NewHash = autorot.HashBytes(newData)
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.New("fix: " + err.Error())
+	}
+	tempPath := path + ".autorot-tmp"
+	if err := ioutil.WriteFile(tempPath, newData, info.Mode()); err != nil {
+		return errors.New("fix: " + err.Error())
+	}
+	if err := f.Journal.Write(entry); err != nil {
+		os.Remove(tempPath)
+		return errors.New("fix: write journal: " + err.Error())
+	}
+	if entry.Backup != "" {
+		if err := writeBackup(entry.Backup, oldData); err != nil {
+			os.Remove(tempPath)
+			return errors.New("fix: write backup: " + err.Error())
+		}
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		if entry.Backup != "" {
+			os.Remove(entry.Backup)
+		}
+		return errors.New("fix: " + err.Error())
+	}
+	return nil
+}
+
+func writeBackup(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package autorot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec"
+)
+
+// OutputCount returns the number of network outputs per
+// image for an output type.
+func OutputCount(outType OutputType) int {
+	switch outType {
+	case RawAngle:
+		return 1
+	case RightAngles:
+		return 4
+	case ConfidenceAngle:
+		return 2
+	default:
+		panic("invalid OutputType")
+	}
+}
+
+// FeatureCount computes the number of outputs a network
+// produces for a single square RGB input.
+func FeatureCount(c anyvec.Creator, net anynet.Net, inputSize int) int {
+	zeroIn := anydiff.NewConst(c.MakeVector(inputSize * inputSize * 3))
+	return net.Apply(zeroIn, 1).Output().Len()
+}
+
+// NewHead creates a randomly initialized linear layer
+// which maps inCount features to the outputs of an output
+// type.
+func NewHead(c anyvec.Creator, inCount int, outType OutputType) anynet.Net {
+	res := anynet.Net{anynet.NewFC(c, inCount, OutputCount(outType))}
+	if outType == RightAngles {
+		res = append(res, anynet.LogSoftmax)
+	}
+	return res
+}
+
+// HeadConfig describes the layers which map the features
+// of a backbone network to the outputs of a Net.
+//
+// The zero value produces the same head as NewHead.
+type HeadConfig struct {
+	// GlobalPool averages the backbone's feature map over
+	// its spatial dimensions before the other layers.
+	GlobalPool bool
+
+	// HiddenLayers is the number of hidden layers, each
+	// with HiddenSize units followed by Activation.
+	HiddenLayers int
+	HiddenSize   int
+	Activation   anynet.Activation
+
+	// Dropout is the probability of dropping each hidden
+	// unit during training.
+	// See SetDropout.
+	Dropout float64
+}
+
+// Build creates a randomly initialized head for a backbone
+// with the given input size.
+func (h *HeadConfig) Build(c anyvec.Creator, backbone anynet.Net, inputSize int,
+	outType OutputType) (anynet.Net, error) {
+	var res anynet.Net
+	inCount := FeatureCount(c, backbone, inputSize)
+	if h.GlobalPool {
+		width, height, depth, err := featureShape(backbone, inCount)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &anyconv.MeanPool{
+			SpanX:       width,
+			SpanY:       height,
+			InputWidth:  width,
+			InputHeight: height,
+			InputDepth:  depth,
+		})
+		inCount = depth
+	}
+	for i := 0; i < h.HiddenLayers; i++ {
+		res = append(res, anynet.NewFC(c, inCount, h.HiddenSize), h.Activation)
+		if h.Dropout > 0 {
+			res = append(res, &anynet.Dropout{KeepProb: 1 - h.Dropout})
+		}
+		inCount = h.HiddenSize
+	}
+	return append(res, NewHead(c, inCount, outType)...), nil
+}
+
+// featureShape infers the spatial shape of a backbone's
+// output from its last layer with a known depth.
+// Feature maps are assumed to be square.
+func featureShape(backbone anynet.Net, count int) (width, height, depth int, err error) {
+	for i := len(backbone) - 1; i >= 0 && depth == 0; i-- {
+		switch layer := backbone[i].(type) {
+		case *anyconv.Conv:
+			depth = layer.OutputDepth()
+		case *anyconv.BatchNorm:
+			depth = layer.InputCount
+		case *anyconv.MaxPool:
+			depth = layer.InputDepth
+		case *anyconv.MeanPool:
+			depth = layer.InputDepth
+		case *anyconv.Padding:
+			depth = layer.InputDepth
+		case *anynet.FC:
+			return 0, 0, 0, errors.New("global pool: backbone output is not a feature map")
+		}
+	}
+	if depth == 0 || count%depth != 0 {
+		return 0, 0, 0, errors.New("global pool: cannot determine feature map depth")
+	}
+	side := int(math.Round(math.Sqrt(float64(count / depth))))
+	if side*side*depth != count {
+		return 0, 0, 0, fmt.Errorf("global pool: %d features are not a square map of depth %d",
+			count, depth)
+	}
+	return side, side, depth, nil
+}
+
+// HeadLength returns the number of trailing layers which
+// make up the head of the network.
+//
+// It uses Metadata.HeadLayers if it is set, falling back
+// to the HeadLength function for older nets.
+func (n *Net) HeadLength() int {
+	if n.Metadata.HeadLayers > 0 && n.Metadata.HeadLayers <= len(n.Net) {
+		return n.Metadata.HeadLayers
+	}
+	return HeadLength(n.Net)
+}
+
+// HeadLength guesses the number of trailing layers of a
+// network which make up its head: fully-connected layers
+// along with their activations and dropout.
+//
+// This is inaccurate for backbones which end with their
+// own fully-connected layers, so Net.HeadLength should be
+// preferred where possible.
+func HeadLength(net anynet.Net) int {
+	var res int
+	for i := len(net) - 1; i >= 0; i-- {
+		switch net[i].(type) {
+		case *anynet.FC, anynet.Activation, *anynet.Dropout:
+			res++
+		default:
+			return res
+		}
+	}
+	return res
+}
+
+// ConvertOutputType replaces the output layer of a Net to
+// produce a different output type.
+// The rest of the network, including any hidden layers of
+// the head, is kept.
+//
+// Where possible, the new output layer is initialized from
+// the old one: the angle output is shared by RawAngle and
+// ConfidenceAngle, so it is copied between them.
+// Otherwise, the new layer is randomly initialized.
+// The return value reports if the old layer was used.
+//
+// The calibration is reset, since it applied to the old
+// outputs.
+func (n *Net) ConvertOutputType(c anyvec.Creator, outType OutputType) (bool, error) {
+	fcIdx := -1
+	for i := len(n.Net) - 1; i >= 0; i-- {
+		if _, ok := n.Net[i].(*anynet.FC); ok {
+			fcIdx = i
+			break
+		}
+	}
+	if fcIdx < 0 {
+		return false, errors.New("convert output type: no output layer found")
+	}
+	oldFC := n.Net[fcIdx].(*anynet.FC)
+	oldType := n.OutputType
+	oldLen := len(n.Net)
+
+	newFC := anynet.NewFC(c, oldFC.InCount, OutputCount(outType))
+	initialized := false
+	angleTypes := map[OutputType]bool{RawAngle: true, ConfidenceAngle: true}
+	if angleTypes[oldType] && angleTypes[outType] {
+		// Row 0 of both types is the angle.
+		oldWeights := oldFC.Weights.Vector.Data().([]float32)
+		oldBiases := oldFC.Biases.Vector.Data().([]float32)
+		weights := newFC.Weights.Vector.Data().([]float32)
+		biases := newFC.Biases.Vector.Data().([]float32)
+		copy(weights[:oldFC.InCount], oldWeights[:oldFC.InCount])
+		biases[0] = oldBiases[0]
+		if outType == ConfidenceAngle {
+			// Predict an angular error of 1, i.e. a
+			// confidence of 0.5, until trained.
+			for i := range weights[oldFC.InCount:] {
+				weights[oldFC.InCount+i] = 0
+			}
+			biases[1] = 1
+		}
+		newFC.Weights.Vector.SetData(weights)
+		newFC.Biases.Vector.SetData(biases)
+		initialized = true
+	}
+
+	n.Net = append(append(anynet.Net{}, n.Net[:fcIdx]...), newFC)
+	if outType == RightAngles {
+		n.Net = append(n.Net, anynet.LogSoftmax)
+	}
+	n.OutputType = outType
+	n.Calibration = Calibration{}
+	if n.Metadata.HeadLayers > 0 {
+		n.Metadata.HeadLayers += len(n.Net) - oldLen
+	}
+	return initialized, nil
+}
+
+// SetDropout enables or disables the dropout layers of a
+// network.
+// Dropout should only be enabled while training.
+func SetDropout(net anynet.Net, enabled bool) {
+	for _, layer := range net {
+		if d, ok := layer.(*anynet.Dropout); ok {
+			d.Enabled = enabled
+		}
+	}
+}
+
+// ParseActivation parses an activation name ("relu",
+// "tanh", or "sigmoid").
+func ParseActivation(name string) (anynet.Activation, error) {
+	switch name {
+	case "relu":
+		return anynet.ReLU, nil
+	case "tanh":
+		return anynet.Tanh, nil
+	case "sigmoid":
+		return anynet.Sigmoid, nil
+	default:
+		return 0, errors.New("unknown activation: " + name)
+	}
+}
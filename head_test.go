@@ -0,0 +1,74 @@
+package autorot
+
+import (
+	"image"
+	"testing"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+func TestHeadLength(t *testing.T) {
+	net := testNet(3, RightAngles)
+	if n := HeadLength(net.Net); n != 4 {
+		t.Errorf("expected head length 4 but got %d", n)
+	}
+	if n := net.HeadLength(); n != 4 {
+		t.Errorf("expected guessed head length 4 but got %d", n)
+	}
+	net.Metadata.HeadLayers = 2
+	if n := net.HeadLength(); n != 2 {
+		t.Errorf("expected recorded head length 2 but got %d", n)
+	}
+
+	if _, err := net.ConvertOutputType(anyvec32.CurrentCreator(), RawAngle); err != nil {
+		t.Fatal(err)
+	}
+	if n := net.HeadLength(); n != 1 {
+		t.Errorf("expected head length 1 after conversion but got %d", n)
+	}
+}
+
+func TestConvertOutputType(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	net := testNet(3, RawAngle)
+	oldFC := net.Net[2].(*anynet.FC)
+	oldWeights := oldFC.Weights.Vector.Data().([]float32)
+	oldBias := oldFC.Biases.Vector.Data().([]float32)[0]
+
+	initialized, err := net.ConvertOutputType(c, ConfidenceAngle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !initialized {
+		t.Error("expected initialization from the old layer")
+	}
+	if net.OutputType != ConfidenceAngle || len(net.Net) != 3 {
+		t.Fatal("unexpected converted network")
+	}
+	newFC := net.Net[2].(*anynet.FC)
+	weights := newFC.Weights.Vector.Data().([]float32)
+	for i, w := range oldWeights {
+		if weights[i] != w {
+			t.Fatalf("weight %d: expected %f but got %f", i, w, weights[i])
+		}
+	}
+	if newFC.Biases.Vector.Data().([]float32)[0] != oldBias {
+		t.Error("angle bias was not copied")
+	}
+
+	initialized, err = net.ConvertOutputType(c, RightAngles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initialized {
+		t.Error("unexpected initialization from the old layer")
+	}
+	if len(net.Net) != 4 || net.Net[3] != anynet.LogSoftmax {
+		t.Error("expected a LogSoftmax output")
+	}
+	angles, _ := net.EvaluateBatch([]image.Image{randomImage(3, 3)})
+	if len(angles) != 1 {
+		t.Error("unexpected output count")
+	}
+}
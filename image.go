@@ -1,14 +1,31 @@
 package autorot
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"math"
+	"os"
 
 	"github.com/unixpickle/num-analysis/linalg"
 	"github.com/unixpickle/num-analysis/linalg/ludecomp"
 )
 
+// ReadImage reads and decodes an image file, returning the
+// name of its format.
+func ReadImage(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode %s: %v", path, err)
+	}
+	return img, format, nil
+}
+
 // Rotate rotates an image around its center and returns
 // the largest centered square cropping that does not go
 // out of the rotated image's bounds.
@@ -55,6 +72,120 @@ func Rotate(img image.Image, angle float64, outSize int) image.Image {
 	return newImage
 }
 
+// Letterbox scales an entire image to fit inside a square
+// with the given side length.
+// The leftover space is filled with black.
+func Letterbox(img image.Image, outSize int) image.Image {
+	return RotateLetterbox(img, 0, outSize)
+}
+
+// RotateLetterbox rotates an image around its center and
+// scales the entire rotated image to fit inside a square
+// with the given side length.
+// The leftover space is filled with black.
+//
+// The angle is specified in clockwise radians.
+func RotateLetterbox(img image.Image, angle float64, outSize int) image.Image {
+	boxWidth, boxHeight := rotatedBounds(img, angle)
+	scale := math.Max(boxWidth, boxHeight) / float64(outSize)
+	return rotateCanvas(img, angle, outSize, outSize, scale)
+}
+
+// RotateExpand rotates an entire image around its center
+// without scaling it.
+// The result is just large enough to contain the rotated
+// image, and the leftover space is filled with black.
+//
+// The angle is specified in clockwise radians.
+func RotateExpand(img image.Image, angle float64) image.Image {
+	boxWidth, boxHeight := rotatedBounds(img, angle)
+	// Avoid an extra row or column due to rounding error.
+	outWidth := int(math.Ceil(boxWidth - 1e-5))
+	outHeight := int(math.Ceil(boxHeight - 1e-5))
+	return rotateCanvas(img, angle, outWidth, outHeight, 1)
+}
+
+func rotatedBounds(img image.Image, angle float64) (width, height float64) {
+	cos := math.Abs(math.Cos(angle))
+	sin := math.Abs(math.Sin(angle))
+	inWidth := float64(img.Bounds().Dx())
+	inHeight := float64(img.Bounds().Dy())
+	return inWidth*cos + inHeight*sin, inWidth*sin + inHeight*cos
+}
+
+// rotateCanvas rotates an image around its center and
+// draws it in the center of a new image.
+// The scale is the number of input pixels per output
+// pixel.
+func rotateCanvas(img image.Image, angle float64, outWidth, outHeight int,
+	scale float64) image.Image {
+	cos := math.Cos(angle)
+	sin := math.Sin(angle)
+
+	width := float64(img.Bounds().Dx())
+	height := float64(img.Bounds().Dy())
+
+	inImage := newRGBACache(img)
+	newImage := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for x := 0; x < outWidth; x++ {
+		for y := 0; y < outHeight; y++ {
+			xOff := scale * (float64(x) - float64(outWidth)/2)
+			yOff := scale * (float64(y) - float64(outHeight)/2)
+			newX := cos*xOff + sin*yOff + width/2
+			newY := cos*yOff - sin*xOff + height/2
+			if newX < 0 || newY < 0 || newX >= width || newY >= height {
+				newImage.SetRGBA(x, y, color.RGBA{A: 0xff})
+			} else {
+				newImage.SetRGBA(x, y, interpolate(inImage, newX, newY))
+			}
+		}
+	}
+
+	return newImage
+}
+
+// RotateRightAngle rotates an image clockwise by the given
+// number of quarter turns.
+// Unlike Rotate, it neither crops nor resamples the image.
+func RotateRightAngle(img image.Image, turns int) image.Image {
+	turns = ((turns % 4) + 4) % 4
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	outWidth, outHeight := width, height
+	if turns%2 == 1 {
+		outWidth, outHeight = height, width
+	}
+	newImage := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var newX, newY int
+			switch turns {
+			case 0:
+				newX, newY = x, y
+			case 1:
+				newX, newY = height-1-y, x
+			case 2:
+				newX, newY = width-1-x, height-1-y
+			case 3:
+				newX, newY = y, width-1-x
+			}
+			newImage.Set(newX, newY, img.At(x+bounds.Min.X, y+bounds.Min.Y))
+		}
+	}
+	return newImage
+}
+
+// cropImage restricts an image to a sub-rectangle without
+// copying any pixels.
+type cropImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+func (c *cropImage) Bounds() image.Rectangle {
+	return c.bounds
+}
+
 func rectFits(axisBasis *ludecomp.LU, sideLength float64) bool {
 	for xScale := -1; xScale <= 1; xScale += 2 {
 		for yScale := -1; yScale <= 1; yScale += 2 {
@@ -2,10 +2,61 @@ package autorot
 
 import (
 	"image"
+	"image/color"
 	"math"
 	"testing"
 )
 
+func TestRotateRightAngle(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x + y*3), A: 0xff})
+		}
+	}
+	expected := [][]uint8{
+		{0, 1, 2, 3, 4, 5},
+		{3, 0, 4, 1, 5, 2},
+		{5, 4, 3, 2, 1, 0},
+		{2, 5, 1, 4, 0, 3},
+	}
+	for turns, exp := range expected {
+		rotated := RotateRightAngle(img, turns)
+		width := rotated.Bounds().Dx()
+		if turns%2 == 1 && width != 2 || turns%2 == 0 && width != 3 {
+			t.Errorf("turns %d: unexpected width %d", turns, width)
+			continue
+		}
+		for i, x := range exp {
+			r, _, _, _ := rotated.At(i%width, i/width).RGBA()
+			if uint8(r>>8) != x {
+				t.Errorf("turns %d pixel %d: expected %d but got %d", turns, i, x, r>>8)
+			}
+		}
+	}
+}
+
+func TestLetterbox(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+		}
+	}
+	boxed := Letterbox(img, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			r, _, _, _ := boxed.At(x, y).RGBA()
+			inside := y == 1 || y == 2
+			if inside && r>>8 != 0xff {
+				t.Errorf("pixel (%d, %d) should be white but got %d", x, y, r>>8)
+			} else if !inside && r != 0 {
+				t.Errorf("pixel (%d, %d) should be black but got %d", x, y, r>>8)
+			}
+		}
+	}
+}
+
 func BenchmarkRotate(b *testing.B) {
 	img := image.NewYCbCr(image.Rect(0, 0, 900, 713), image.YCbCrSubsampleRatio444)
 	b.ResetTimer()
@@ -13,3 +64,13 @@ func BenchmarkRotate(b *testing.B) {
 		Rotate(img, math.Pi/7, 224)
 	}
 }
+
+func TestRotateExpand(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	if b := RotateExpand(img, math.Pi/2).Bounds(); b.Dx() != 20 || b.Dy() != 30 {
+		t.Errorf("unexpected bounds after 90 degrees: %v", b)
+	}
+	if b := RotateExpand(img, math.Pi/4).Bounds(); b.Dx() != 36 || b.Dy() != 36 {
+		t.Errorf("unexpected bounds after 45 degrees: %v", b)
+	}
+}
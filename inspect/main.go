@@ -0,0 +1,81 @@
+// Command inspect prints the configuration, metadata, and
+// layers of an autorot network.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+type parameterizer interface {
+	Parameters() []*anydiff.Var
+}
+
+func main() {
+	var netPath string
+	flag.StringVar(&netPath, "net", "", "network path")
+	flag.Parse()
+	if netPath == "" {
+		essentials.Die("Required flag: -net. See -help for more.")
+	}
+
+	var net *autorot.Net
+	if err := serializer.LoadAny(netPath, &net); err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	meta := net.Metadata
+	created := "unknown"
+	if !meta.Created.IsZero() {
+		created = meta.Created.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "Input size:\t%d\n", net.InputSize)
+	fmt.Fprintf(w, "Input mode:\t%s\n", net.InputMode)
+	fmt.Fprintf(w, "Output type:\t%s\n", net.OutputType)
+	fmt.Fprintf(w, "Created:\t%s\n", created)
+	fmt.Fprintf(w, "Iterations:\t%d\n", meta.Iterations)
+	fmt.Fprintf(w, "Dataset hash:\t%s\n", meta.DatasetHash)
+	fmt.Fprintf(w, "Input normalization:\t%s\n", meta.InputNormalization)
+	fmt.Fprintf(w, "Angle convention:\t%s\n", meta.AngleConvention)
+	fmt.Fprintf(w, "Notes:\t%s\n", meta.Notes)
+	if meta.HeadLayers > 0 {
+		fmt.Fprintf(w, "Head layers:\t%d\n", meta.HeadLayers)
+	} else {
+		fmt.Fprintf(w, "Head layers:\t%d (guessed)\n", net.HeadLength())
+	}
+	switch net.Calibration.Method {
+	case autorot.NoCalibration:
+		fmt.Fprintf(w, "Calibration:\tnone\n")
+	case autorot.TemperatureScaling:
+		fmt.Fprintf(w, "Calibration:\ttemperature %f\n", net.Calibration.Temperature)
+	case autorot.IsotonicCalibration:
+		fmt.Fprintf(w, "Calibration:\tisotonic, %d knots\n", len(net.Calibration.IsotonicX))
+	}
+	w.Flush()
+
+	fmt.Println()
+	fmt.Println("Layers:")
+	var total int
+	for i, layer := range net.Net {
+		var count int
+		if p, ok := layer.(parameterizer); ok {
+			for _, param := range p.Parameters() {
+				count += param.Vector.Len()
+			}
+		}
+		total += count
+		fmt.Fprintf(w, "%d\t%T\t%d params\n", i, layer, count)
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("Total parameters:", total)
+}
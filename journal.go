@@ -0,0 +1,141 @@
+package autorot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// Transforms which may be recorded in a journal.
+const (
+	// ExifTransform changes the EXIF orientation tag of a
+	// JPEG in place, leaving the pixels untouched.
+	ExifTransform = "exif-orientation"
+
+	// RewriteTransform replaces a file with a corrected
+	// copy after backing up the original.
+	RewriteTransform = "rewrite"
+)
+
+// A JournalEntry records a change made to an image file so
+// that the change can be undone.
+type JournalEntry struct {
+	Path string `json:"path"`
+
+	// OriginalHash and NewHash are the SHA-256 hashes of
+	// the file before and after the change.
+	OriginalHash string `json:"original_hash"`
+	NewHash      string `json:"new_hash"`
+
+	Transform string `json:"transform"`
+
+	// Angle is the predicted clockwise rotation which the
+	// change was meant to undo.
+	Angle      float64 `json:"angle"`
+	Confidence float64 `json:"confidence"`
+
+	// OldOrientation and NewOrientation are set for
+	// ExifTransform.
+	OldOrientation int `json:"old_orientation,omitempty"`
+	NewOrientation int `json:"new_orientation,omitempty"`
+
+	// Backup is the path of the original file for
+	// RewriteTransform.
+	Backup string `json:"backup,omitempty"`
+
+	// Model identifies the network that made the
+	// prediction, typically by the hash of its file.
+	Model string `json:"model"`
+
+	Time time.Time `json:"time"`
+}
+
+// A JournalWriter appends entries to a journal file.
+type JournalWriter struct {
+	f *os.File
+}
+
+// OpenJournal opens a journal for appending, creating it
+// if necessary.
+func OpenJournal(path string) (*JournalWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalWriter{f: f}, nil
+}
+
+// Write appends an entry and syncs it to disk, so that the
+// journal is never behind the changes it describes.
+func (j *JournalWriter) Write(e *JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Close closes the journal file.
+func (j *JournalWriter) Close() error {
+	return j.f.Close()
+}
+
+// ReadJournal reads the entries of a journal.
+//
+// A truncated final line, which may be left behind by an
+// interrupted process, is ignored.
+func ReadJournal(path string) ([]*JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []*JournalEntry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// The final line is incomplete (or empty).
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		res = append(res, &entry)
+	}
+}
+
+// HashFile computes the hex-encoded SHA-256 hash of a file.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashBytes computes the hex-encoded SHA-256 hash of data.
+func HashBytes(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
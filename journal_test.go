@@ -0,0 +1,54 @@
+package autorot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autorot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+
+	entries := []*JournalEntry{
+		{Path: "a.jpg", Transform: ExifTransform, OldOrientation: 1, NewOrientation: 6},
+		{Path: "b.png", Transform: RewriteTransform, Backup: "backup/b.png"},
+	}
+	for _, e := range entries {
+		e.Time = time.Unix(1500000000, 0).UTC()
+		w, err := OpenJournal(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"path": "c.jp`))
+	f.Close()
+
+	actual, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != len(entries) {
+		t.Fatalf("expected %d entries but got %d", len(entries), len(actual))
+	}
+	for i, e := range entries {
+		if *actual[i] != *e {
+			t.Errorf("entry %d: expected %v but got %v", i, e, actual[i])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package autorot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// A Label records the true orientation of an image, as
+// decided by a person.
+type Label struct {
+	Path string `json:"path"`
+
+	// Angle is the clockwise rotation, in radians, by which
+	// the stored image is rotated away from upright.
+	// It uses the same convention as a Net's predictions.
+	Angle float64 `json:"angle"`
+
+	// Skip indicates that the image should not be used for
+	// training, e.g. because it has no clear orientation.
+	Skip bool `json:"skip,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// AppendLabel adds a label to a manifest file, creating
+// the file if necessary.
+//
+// A manifest is a JSON Lines file, so later labels for the
+// same path override earlier ones.
+func AppendLabel(path string, l *Label) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadLabels reads the latest label for each path in a
+// manifest file, in the order the paths first appear.
+//
+// A truncated final line is ignored.
+func ReadLabels(path string) ([]*Label, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []*Label
+	indices := map[string]int{}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var label Label
+		if err := json.Unmarshal(line, &label); err != nil {
+			return nil, err
+		}
+		if idx, ok := indices[label.Path]; ok {
+			res[idx] = &label
+		} else {
+			indices[label.Path] = len(res)
+			res = append(res, &label)
+		}
+	}
+}
+
+// ReadManifest creates a sample for each labeled image in
+// a manifest file, skipping images labeled with Skip.
+func ReadManifest(imageSize int, path string) (*SampleList, error) {
+	labels, err := ReadLabels(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &SampleList{ImageSize: imageSize, Orientations: []float64{}}
+	for _, label := range labels {
+		if !label.Skip {
+			res.Paths = append(res.Paths, label.Path)
+			res.Orientations = append(res.Orientations, label.Angle)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,56 @@
+package autorot
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autorot-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "labels.jsonl")
+
+	labels := []*Label{
+		{Path: "a.jpg", Angle: math.Pi / 2},
+		{Path: "b.jpg", Angle: 0},
+		{Path: "c.jpg", Skip: true},
+		{Path: "a.jpg", Angle: math.Pi},
+	}
+	for _, l := range labels {
+		if err := AppendLabel(path, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := ReadManifest(32, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(samples.Paths, []string{"a.jpg", "b.jpg"}) {
+		t.Errorf("unexpected paths: %v", samples.Paths)
+	}
+	if !reflect.DeepEqual(samples.Orientations, []float64{math.Pi, 0}) {
+		t.Errorf("unexpected orientations: %v", samples.Orientations)
+	}
+
+	samples.Swap(0, 1)
+	if samples.Paths[0] != "b.jpg" || samples.Orientations[0] != 0 {
+		t.Error("swap did not move orientations")
+	}
+
+	upright := &SampleList{Paths: []string{"d.jpg"}}
+	upright.Append(samples)
+	if !reflect.DeepEqual(upright.Orientations, []float64{0, 0, math.Pi}) {
+		t.Errorf("unexpected orientations after append: %v", upright.Orientations)
+	}
+	if upright.Hash() == (&SampleList{Paths: upright.Paths}).Hash() {
+		t.Error("orientations should affect the hash")
+	}
+}
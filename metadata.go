@@ -0,0 +1,83 @@
+package autorot
+
+import (
+	"time"
+
+	"github.com/unixpickle/serializer"
+)
+
+// Descriptions of the conventions used by this package.
+// They are stored in the Metadata of new networks so that
+// a serialized Net can be interpreted on its own.
+const (
+	InputNormalization = "row-major RGB, each component scaled to [0, 1]"
+	AngleConvention    = "clockwise radians by which the upright image was rotated"
+)
+
+// Metadata describes how a Net was created and trained.
+type Metadata struct {
+	// DatasetHash identifies the most recent training data,
+	// as computed by SampleList.Hash.
+	DatasetHash string
+
+	// Iterations is the total number of training steps.
+	Iterations int
+
+	Created time.Time
+
+	InputNormalization string
+	AngleConvention    string
+
+	// Notes is free-form text for humans.
+	Notes string
+
+	// HeadLayers is the number of trailing layers of the
+	// network which were built as its head, or 0 if it is
+	// unknown (see Net.HeadLength).
+	HeadLayers int
+}
+
+// NewMetadata creates metadata for a net created now.
+func NewMetadata() Metadata {
+	return Metadata{
+		Created:            time.Now(),
+		InputNormalization: InputNormalization,
+		AngleConvention:    AngleConvention,
+	}
+}
+
+// deserializeMetadata decodes metadata which was saved
+// with the given net format version.
+func deserializeMetadata(d []byte, version int) (Metadata, error) {
+	var res Metadata
+	var created int
+	targets := []interface{}{&res.DatasetHash, &res.Iterations, &created,
+		&res.InputNormalization, &res.AngleConvention, &res.Notes}
+	if version >= 4 {
+		targets = append(targets, &res.HeadLayers)
+	}
+	err := serializer.DeserializeAny(d, targets...)
+	if err != nil {
+		return res, err
+	}
+	if created != 0 {
+		res.Created = time.Unix(int64(created), 0)
+	}
+	return res, nil
+}
+
+func (m *Metadata) serialize() ([]byte, error) {
+	var created int
+	if !m.Created.IsZero() {
+		created = int(m.Created.Unix())
+	}
+	return serializer.SerializeAny(
+		serializer.String(m.DatasetHash),
+		serializer.Int(m.Iterations),
+		serializer.Int(created),
+		serializer.String(m.InputNormalization),
+		serializer.String(m.AngleConvention),
+		serializer.String(m.Notes),
+		serializer.Int(m.HeadLayers),
+	)
+}
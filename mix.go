@@ -0,0 +1,132 @@
+package autorot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+
+	"github.com/unixpickle/anynet/anyff"
+	"github.com/unixpickle/anynet/anysgd"
+)
+
+// A MixedSampleList combines a main SampleList with a list
+// of hard examples, such as images whose predictions were
+// corrected during review.
+//
+// The samples are laid out in batches of BatchSize, each
+// of which contains HardPerBatch hard examples (repeated
+// as needed) and main samples for the rest.
+// Swap only exchanges samples of the same kind, so the
+// layout survives shuffling.
+type MixedSampleList struct {
+	Main *SampleList
+	Hard *SampleList
+
+	// Ratio is the requested fraction of hard samples.
+	Ratio float64
+
+	BatchSize    int
+	HardPerBatch int
+
+	entries []mixEntry
+}
+
+type mixEntry struct {
+	Hard  bool
+	Index int
+}
+
+// NewMixedSampleList creates a MixedSampleList in which
+// round(ratio*batchSize) samples of every batch come from
+// hard, where ratio is in [0, 1).
+//
+// At least one sample per batch comes from main, unless
+// main is empty.
+func NewMixedSampleList(main, hard *SampleList, ratio float64,
+	batchSize int) *MixedSampleList {
+	res := &MixedSampleList{Main: main, Hard: hard, Ratio: ratio, BatchSize: batchSize}
+	if len(hard.Paths) == 0 || ratio <= 0 {
+		for i := range main.Paths {
+			res.entries = append(res.entries, mixEntry{Index: i})
+		}
+		return res
+	}
+	if len(main.Paths) == 0 {
+		for i := range hard.Paths {
+			res.entries = append(res.entries, mixEntry{Hard: true, Index: i})
+		}
+		res.HardPerBatch = batchSize
+		return res
+	}
+
+	res.HardPerBatch = int(math.Round(ratio * float64(batchSize)))
+	if res.HardPerBatch >= batchSize {
+		res.HardPerBatch = batchSize - 1
+	}
+	mainPerBatch := batchSize - res.HardPerBatch
+	var hardIdx int
+	for start := 0; start < len(main.Paths); start += mainPerBatch {
+		numMain := mainPerBatch
+		numHard := res.HardPerBatch
+		if start+numMain > len(main.Paths) {
+			// Keep the ratio for the final, smaller batch.
+			numMain = len(main.Paths) - start
+			numHard = int(math.Round(float64(numMain) * float64(res.HardPerBatch) /
+				float64(mainPerBatch)))
+		}
+		for i := 0; i < numHard; i++ {
+			res.entries = append(res.entries, mixEntry{Hard: true, Index: hardIdx})
+			hardIdx = (hardIdx + 1) % len(hard.Paths)
+		}
+		for i := start; i < start+numMain; i++ {
+			res.entries = append(res.entries, mixEntry{Index: i})
+		}
+	}
+	return res
+}
+
+// Len returns the number of samples, including repeated
+// hard examples.
+func (m *MixedSampleList) Len() int {
+	return len(m.entries)
+}
+
+// Swap swaps two sample indices if they are both hard or
+// both main samples, and does nothing otherwise.
+func (m *MixedSampleList) Swap(i, j int) {
+	if m.entries[i].Hard == m.entries[j].Hard {
+		m.entries[i], m.entries[j] = m.entries[j], m.entries[i]
+	}
+}
+
+// Slice returns a subset of the list.
+func (m *MixedSampleList) Slice(i, j int) anysgd.SampleList {
+	return &MixedSampleList{
+		Main:         m.Main,
+		Hard:         m.Hard,
+		Ratio:        m.Ratio,
+		BatchSize:    m.BatchSize,
+		HardPerBatch: m.HardPerBatch,
+		entries:      append([]mixEntry{}, m.entries[i:j]...),
+	}
+}
+
+// GetSample generates the sample at the given index.
+func (m *MixedSampleList) GetSample(idx int) (*anyff.Sample, error) {
+	entry := m.entries[idx]
+	if entry.Hard {
+		return m.Hard.GetSample(entry.Index)
+	}
+	return m.Main.GetSample(entry.Index)
+}
+
+// Hash computes a hash which identifies both lists and
+// the batch layout.
+func (m *MixedSampleList) Hash() string {
+	h := sha256.New()
+	h.Write([]byte(m.Main.Hash()))
+	h.Write([]byte(m.Hard.Hash()))
+	h.Write([]byte(strconv.Itoa(m.HardPerBatch) + "/" + strconv.Itoa(m.BatchSize)))
+	return hex.EncodeToString(h.Sum(nil))
+}
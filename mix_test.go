@@ -0,0 +1,66 @@
+package autorot
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMixedSampleList(t *testing.T) {
+	main := &SampleList{Paths: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}}
+	hard := &SampleList{Paths: []string{"x", "y", "z"}, Orientations: []float64{0, 1, 2}}
+	tests := []struct {
+		Ratio     float64
+		BatchSize int
+		PerBatch  int
+	}{
+		{0.25, 4, 1},
+		{0.25, 8, 2},
+		{0.5, 4, 2},
+		{0.9, 4, 3},
+		{0, 4, 0},
+	}
+	for _, test := range tests {
+		mixed := NewMixedSampleList(main, hard, test.Ratio, test.BatchSize)
+		if mixed.HardPerBatch != test.PerBatch {
+			t.Errorf("ratio %f batch %d: expected %d hard per batch but got %d",
+				test.Ratio, test.BatchSize, test.PerBatch, mixed.HardPerBatch)
+			continue
+		}
+		for epoch := 0; epoch < 3; epoch++ {
+			for i := mixed.Len() - 1; i > 0; i-- {
+				mixed.Swap(i, rand.Intn(i+1))
+			}
+			mainCounts := map[int]int{}
+			for start := 0; start < mixed.Len(); start += test.BatchSize {
+				end := start + test.BatchSize
+				if end > mixed.Len() {
+					end = mixed.Len()
+				}
+				batch := mixed.Slice(start, end).(*MixedSampleList)
+				var numHard int
+				for _, entry := range batch.entries {
+					if entry.Hard {
+						numHard++
+					} else {
+						mainCounts[entry.Index]++
+					}
+				}
+				if end-start == test.BatchSize && numHard != test.PerBatch {
+					t.Errorf("ratio %f batch %d: batch at %d has %d hard samples",
+						test.Ratio, test.BatchSize, start, numHard)
+				}
+			}
+			for i := range main.Paths {
+				if mainCounts[i] != 1 {
+					t.Errorf("ratio %f batch %d: main sample %d used %d times",
+						test.Ratio, test.BatchSize, i, mainCounts[i])
+				}
+			}
+		}
+	}
+
+	if NewMixedSampleList(main, hard, 0.5, 4).Hash() ==
+		NewMixedSampleList(main, hard, 0.25, 4).Hash() {
+		t.Error("the batch layout should affect the hash")
+	}
+}
@@ -1,7 +1,10 @@
 package autorot
 
 import (
+	"errors"
+	"fmt"
 	"image"
+	"io/ioutil"
 	"math"
 
 	"github.com/unixpickle/anydiff"
@@ -23,26 +26,230 @@ const (
 	ConfidenceAngle
 )
 
+// String returns the name of the output type.
+func (o OutputType) String() string {
+	switch o {
+	case RawAngle:
+		return "RawAngle"
+	case RightAngles:
+		return "RightAngles"
+	case ConfidenceAngle:
+		return "ConfidenceAngle"
+	default:
+		return fmt.Sprintf("OutputType(%d)", int(o))
+	}
+}
+
+// InputMode specifies how an image is fit into the square
+// input of a network.
+type InputMode int
+
+const (
+	// CenterCrop uses the largest centered square that fits
+	// inside the image.
+	CenterCrop InputMode = iota
+
+	// Letterboxed scales the entire image to fit inside the
+	// square and pads the leftover space.
+	Letterboxed
+)
+
+// String returns the name of the input mode.
+func (i InputMode) String() string {
+	switch i {
+	case CenterCrop:
+		return "CenterCrop"
+	case Letterboxed:
+		return "Letterboxed"
+	default:
+		return fmt.Sprintf("InputMode(%d)", int(i))
+	}
+}
+
+// fitInput rotates an image by a clockwise angle and fits
+// the result into a square of the given size.
+func fitInput(img image.Image, angle float64, size int, mode InputMode) image.Image {
+	switch mode {
+	case CenterCrop:
+		return Rotate(img, angle, size)
+	case Letterboxed:
+		return RotateLetterbox(img, angle, size)
+	default:
+		panic("invalid InputMode")
+	}
+}
+
+// netVersion is the current serialization format version.
+// Nets serialized before versioning was introduced are
+// still supported by DeserializeNet.
+const netVersion = 4
+
 func init() {
 	var n Net
 	serializer.RegisterTypedDeserializer(n.SerializerType(), DeserializeNet)
 }
 
+// An Evaluator predicts the rotations of images.
+type Evaluator interface {
+	Evaluate(img image.Image) (angle, confidence float64)
+	EvaluateBatch(imgs []image.Image) (angles, confidences []float64)
+	EvaluateTTA(imgs []image.Image, t *TTA) (angles, confidences []float64)
+}
+
+// LoadEvaluator loads any serialized Evaluator, such as a
+// *Net or an *Ensemble, from a file.
+func LoadEvaluator(path string) (Evaluator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeEvaluator(data)
+}
+
+// DeserializeEvaluator is like LoadEvaluator, but it
+// reads the serialized object from memory.
+func DeserializeEvaluator(data []byte) (Evaluator, error) {
+	var obj serializer.Serializer
+	if err := serializer.DeserializeAny(data, &obj); err != nil {
+		return nil, err
+	}
+	if e, ok := obj.(Evaluator); ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("load evaluator: unsupported type %T", obj)
+}
+
+// HasConfidence checks if an Evaluator produces
+// meaningful confidences.
+//
+// A RawAngle net always reports a confidence of 0, as does
+// an Ensemble made up of RawAngle nets, so confidence
+// thresholds would reject every prediction.
+func HasConfidence(e Evaluator) bool {
+	switch e := e.(type) {
+	case *Net:
+		return e.OutputType != RawAngle
+	case *Ensemble:
+		for _, net := range e.Nets {
+			if net.OutputType != RawAngle {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// CloneEvaluator creates a deep copy of an Evaluator by
+// serializing and deserializing it.
+//
+// Evaluators are not safe for concurrent use (see Net), so
+// each goroutine should evaluate with its own copy.
+// Compiled plans are immutable, so they are shared with the
+// copy rather than duplicated.
+func CloneEvaluator(e Evaluator) (Evaluator, error) {
+	s, ok := e.(serializer.Serializer)
+	if !ok {
+		return nil, fmt.Errorf("clone evaluator: unsupported type %T", e)
+	}
+	data, err := serializer.SerializeWithType(s)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := serializer.DeserializeWithType(data)
+	if err != nil {
+		return nil, err
+	}
+	if clone, ok := obj.(Evaluator); ok {
+		copyPlans(e, clone)
+		return clone, nil
+	}
+	return nil, fmt.Errorf("clone evaluator: unsupported type %T", obj)
+}
+
 // A Net is a neural net that predicts angles from images.
+//
+// Evaluating a Net does not modify the Net itself, but the
+// layers of the underlying anynet.Net make no promises
+// about concurrent Apply calls (for example, anyconv layers
+// may lazily initialize internal caches on first use).
+// Thus, a Net should not be evaluated from multiple
+// goroutines at once; use CloneEvaluator to create a copy
+// for each goroutine instead.
 type Net struct {
 	// Side length of input images.
 	InputSize int
 
 	OutputType OutputType
+	InputMode  InputMode
 	Net        anynet.Net
+
+	Metadata Metadata
+
+	// Calibration is applied to the confidences produced by
+	// the network.
+	Calibration Calibration
+
+	// plan, if non-nil, is used instead of Net for
+	// evaluation.
+	// See CompilePlan.
+	plan *Plan
 }
 
 // DeserializeNet deserializes a Net.
 func DeserializeNet(d []byte) (*Net, error) {
+	var version int
+	var body []byte
+	if err := serializer.DeserializeAny(d, &version, &body); err != nil {
+		return deserializeLegacyNet(d)
+	}
+	if version < 2 || version > netVersion {
+		return nil, fmt.Errorf("deserialize net: unsupported version %d", version)
+	}
 	var res Net
-	err := serializer.DeserializeAny(d, &res.InputSize, &res.OutputType, &res.Net)
+	var metadata, calibration []byte
+	targets := []interface{}{&res.InputSize, &res.OutputType, &res.InputMode, &res.Net,
+		&metadata}
+	if version >= 3 {
+		targets = append(targets, &calibration)
+	}
+	if err := serializer.DeserializeAny(body, targets...); err != nil {
+		return nil, errors.New("deserialize net: " + err.Error())
+	}
+	var err error
+	res.Metadata, err = deserializeMetadata(metadata, version)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("deserialize net metadata: " + err.Error())
+	}
+	if version >= 3 {
+		res.Calibration, err = deserializeCalibration(calibration)
+		if err != nil {
+			return nil, errors.New("deserialize net calibration: " + err.Error())
+		}
+	}
+	return &res, nil
+}
+
+// deserializeLegacyNet decodes nets which were saved
+// before the format was versioned.
+// Such nets have no metadata, so it is assumed that they
+// follow the current conventions.
+func deserializeLegacyNet(d []byte) (*Net, error) {
+	res := Net{
+		Metadata: Metadata{
+			InputNormalization: InputNormalization,
+			AngleConvention:    AngleConvention,
+		},
+	}
+	err := serializer.DeserializeAny(d, &res.InputSize, &res.OutputType, &res.Net,
+		&res.InputMode)
+	if err != nil {
+		// Nets saved before InputMode existed use CenterCrop.
+		res.InputMode = CenterCrop
+		err = serializer.DeserializeAny(d, &res.InputSize, &res.OutputType, &res.Net)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &res, nil
 }
@@ -54,29 +261,116 @@ func DeserializeNet(d []byte) (*Net, error) {
 // It should range between 0 and 1.
 // Some output types do not yield a confidence measure.
 func (n *Net) Evaluate(img image.Image) (angle, confidence float64) {
-	if img.Bounds().Dx() != img.Bounds().Dy() ||
-		img.Bounds().Dx() != n.InputSize {
-		// Hack to crop the center square.
-		img = Rotate(img, 0, n.InputSize)
+	angles, confidences := n.EvaluateBatch([]image.Image{img})
+	return angles[0], confidences[0]
+}
+
+// EvaluateBatch is like Evaluate, but it runs all of the
+// images through the network in a single batch.
+func (n *Net) EvaluateBatch(imgs []image.Image) (angles, confidences []float64) {
+	if len(imgs) == 0 {
+		return nil, nil
+	}
+	return n.decodeOutputs(n.applyBatch(n.fitInputs(imgs)), len(imgs))
+}
+
+// fitInputs converts images to the network's input size.
+func (n *Net) fitInputs(imgs []image.Image) []image.Image {
+	var inputs []image.Image
+	for _, img := range imgs {
+		if img.Bounds().Dx() != img.Bounds().Dy() ||
+			img.Bounds().Dx() != n.InputSize {
+			img = fitInput(img, 0, n.InputSize, n.InputMode)
+		}
+		inputs = append(inputs, img)
+	}
+	return inputs
+}
+
+// RightAngleProbs computes, for each image, the
+// probability that it was rotated by each right angle.
+//
+// For RightAngles nets, TemperatureScaling is applied to
+// the distribution, but IsotonicCalibration is not.
+//
+// For other output types, the nearest right angle gets the
+// (calibrated) confidence of the prediction and the rest
+// of the probability mass is spread evenly.
+// Predictions without a confidence are treated as certain.
+func (n *Net) RightAngleProbs(imgs []image.Image) [][4]float64 {
+	_, _, probs := n.EvaluateProbs(imgs)
+	return probs
+}
+
+// EvaluateProbs is like EvaluateBatch, but it also
+// produces the right angle probabilities described in
+// RightAngleProbs using the same forward pass.
+func (n *Net) EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+	probs [][4]float64) {
+	if len(imgs) == 0 {
+		return nil, nil, nil
+	}
+	out := n.applyBatch(n.fitInputs(imgs))
+	angles, confidences = n.decodeOutputs(out, len(imgs))
+	if n.OutputType == RightAngles {
+		data := out.Data().([]float32)
+		probs = make([][4]float64, len(imgs))
+		for i := range probs {
+			probs[i] = n.Calibration.applyLogProbs(data[i*4 : (i+1)*4])
+		}
+	} else {
+		probs = anglesToProbs(angles, confidences, n.OutputType != RawAngle)
+	}
+	return
+}
+
+// applyBatch runs the network on images which are already
+// the size of the network's input.
+func (n *Net) applyBatch(imgs []image.Image) anyvec.Vector {
+	var inTensor []float32
+	for _, img := range imgs {
+		inTensor = append(inTensor, netInputTensor(img)...)
+	}
+	if n.plan != nil {
+		return anyvec32.MakeVectorData(n.plan.Apply(inTensor, len(imgs)))
 	}
-	inTensor := netInputTensor(img)
 	inConst := anydiff.NewConst(anyvec32.MakeVectorData(inTensor))
-	out := n.Net.Apply(inConst, 1).Output()
+	return n.Net.Apply(inConst, len(imgs)).Output()
+}
+
+func (n *Net) decodeOutputs(out anyvec.Vector, num int) (angles, confidences []float64) {
+	angles = make([]float64, num)
+	confidences = make([]float64, num)
 	switch n.OutputType {
 	case RawAngle:
-		return float64(anyvec.Sum(out).(float32)), 0
+		for i, x := range out.Data().([]float32) {
+			angles[i] = float64(x)
+		}
 	case RightAngles:
-		angles, probs := rightAngleMaxes(out)
-		return float64(anyvec.Sum(angles).(float32)),
-			float64(anyvec.Sum(probs).(float32))
+		angleVec, probVec := rightAngleMaxes(out)
+		probs := probVec.Data().([]float32)
+		data := out.Data().([]float32)
+		for i, x := range angleVec.Data().([]float32) {
+			angles[i] = float64(x)
+			if n.Calibration.Method == TemperatureScaling {
+				calibrated := n.Calibration.applyLogProbs(data[i*4 : (i+1)*4])
+				confidences[i] = calibrated[NearestRightAngle(angles[i])]
+			} else {
+				confidences[i] = n.Calibration.Apply(float64(probs[i]))
+			}
+		}
 	case ConfidenceAngle:
-		angle := float64(anyvec.Sum(out.Slice(0, 1)).(float32))
-		confidence := float64(anyvec.Sum(out.Slice(1, 2)).(float32))
-		confidence = math.Max(0, math.Min(1, (2-confidence)/2))
-		return angle, confidence
+		data := out.Data().([]float32)
+		for i := 0; i < num; i++ {
+			angles[i] = float64(data[i*2])
+			confidence := float64(data[i*2+1])
+			confidence = math.Max(0, math.Min(1, (2-confidence)/2))
+			confidences[i] = n.Calibration.Apply(confidence)
+		}
 	default:
 		panic("invalid OutputType")
 	}
+	return
 }
 
 // Cost computes the total cost, given the desired output
@@ -114,11 +408,26 @@ func (n *Net) SerializerType() string {
 
 // Serialize serializes the Net.
 func (n *Net) Serialize() ([]byte, error) {
-	return serializer.SerializeAny(
+	metadata, err := n.Metadata.serialize()
+	if err != nil {
+		return nil, err
+	}
+	calibration, err := n.Calibration.serialize()
+	if err != nil {
+		return nil, err
+	}
+	body, err := serializer.SerializeAny(
 		serializer.Int(n.InputSize),
 		serializer.Int(n.OutputType),
+		serializer.Int(n.InputMode),
 		n.Net,
+		serializer.Bytes(metadata),
+		serializer.Bytes(calibration),
 	)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.SerializeAny(serializer.Int(netVersion), serializer.Bytes(body))
 }
 
 func rightAngleOneHots(angles anyvec.Vector) anyvec.Vector {
@@ -1,11 +1,16 @@
 package autorot
 
 import (
+	"image"
+	"image/color"
 	"math"
+	"math/rand"
 	"testing"
 
 	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
 	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/serializer"
 )
 
 func TestNetworkCost(t *testing.T) {
@@ -75,3 +80,154 @@ func TestRightAngleMaxes(t *testing.T) {
 		}
 	}
 }
+
+func TestEvaluateBatch(t *testing.T) {
+	imgs := []image.Image{
+		randomImage(6, 6),
+		randomImage(9, 13),
+		randomImage(6, 6),
+		randomImage(20, 7),
+	}
+	for _, outType := range []OutputType{RawAngle, RightAngles, ConfidenceAngle} {
+		net := testNet(6, outType)
+		if outType == ConfidenceAngle {
+			net.InputMode = Letterboxed
+		}
+		angles, confidences := net.EvaluateBatch(imgs)
+		if len(angles) != len(imgs) || len(confidences) != len(imgs) {
+			t.Fatalf("type %d: bad output lengths", outType)
+		}
+		for i, img := range imgs {
+			angle, confidence := net.Evaluate(img)
+			if math.Abs(angle-angles[i]) > 1e-5 {
+				t.Errorf("type %d image %d: angle should be %f but got %f",
+					outType, i, angle, angles[i])
+			}
+			if math.Abs(confidence-confidences[i]) > 1e-5 {
+				t.Errorf("type %d image %d: confidence should be %f but got %f",
+					outType, i, confidence, confidences[i])
+			}
+		}
+	}
+}
+
+func TestEvaluateLetterboxed(t *testing.T) {
+	img := randomImage(12, 5)
+	net := testNet(6, RawAngle)
+	net.InputMode = Letterboxed
+	actual, _ := net.Evaluate(img)
+	expected, _ := net.Evaluate(Letterbox(img, 6))
+	if actual != expected {
+		t.Errorf("expected %f but got %f", expected, actual)
+	}
+	cropped, _ := net.Evaluate(Rotate(img, 0, 6))
+	if actual == cropped {
+		t.Error("letterboxed prediction should differ from center crop")
+	}
+}
+
+func TestNetSerialize(t *testing.T) {
+	net := testNet(6, RightAngles)
+	net.InputMode = Letterboxed
+	net.Metadata = NewMetadata()
+	net.Metadata.Iterations = 17
+	net.Metadata.DatasetHash = "abc"
+	net.Metadata.Notes = "hello"
+	net.Metadata.HeadLayers = 2
+
+	data, err := net.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeNet(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.InputSize != net.InputSize || decoded.OutputType != net.OutputType ||
+		decoded.InputMode != net.InputMode {
+		t.Error("configuration mismatch")
+	}
+	if decoded.Metadata.Created.Unix() != net.Metadata.Created.Unix() {
+		t.Error("creation time mismatch")
+	}
+	decoded.Metadata.Created = net.Metadata.Created
+	if decoded.Metadata != net.Metadata {
+		t.Errorf("expected metadata %v but got %v", net.Metadata, decoded.Metadata)
+	}
+	img := randomImage(6, 6)
+	expected, _ := net.Evaluate(img)
+	actual, _ := decoded.Evaluate(img)
+	if actual != expected {
+		t.Errorf("expected output %f but got %f", expected, actual)
+	}
+}
+
+func TestDeserializeLegacyNet(t *testing.T) {
+	net := testNet(6, ConfidenceAngle)
+	data, err := serializer.SerializeAny(
+		serializer.Int(net.InputSize),
+		serializer.Int(net.OutputType),
+		net.Net,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeNet(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.InputSize != 6 || decoded.OutputType != ConfidenceAngle ||
+		decoded.InputMode != CenterCrop {
+		t.Error("configuration mismatch")
+	}
+	if decoded.Metadata.AngleConvention != AngleConvention {
+		t.Error("missing default metadata")
+	}
+}
+
+func TestHasConfidence(t *testing.T) {
+	raw := &Net{OutputType: RawAngle}
+	probs := &Net{OutputType: RightAngles}
+	if HasConfidence(raw) {
+		t.Error("unexpected confidence for RawAngle net")
+	}
+	if !HasConfidence(probs) {
+		t.Error("expected confidence for RightAngles net")
+	}
+	if HasConfidence(&Ensemble{Nets: []*Net{raw, raw}}) {
+		t.Error("unexpected confidence for RawAngle ensemble")
+	}
+	if !HasConfidence(&Ensemble{Nets: []*Net{raw, probs}}) {
+		t.Error("expected confidence for mixed ensemble")
+	}
+}
+
+func testNet(inSize int, outType OutputType) *Net {
+	c := anyvec32.CurrentCreator()
+	inCount := inSize * inSize * 3
+	net := anynet.Net{anynet.NewFC(c, inCount, 5), anynet.Tanh}
+	switch outType {
+	case RawAngle:
+		net = append(net, anynet.NewFC(c, 5, 1))
+	case RightAngles:
+		net = append(net, anynet.NewFC(c, 5, 4), anynet.LogSoftmax)
+	case ConfidenceAngle:
+		net = append(net, anynet.NewFC(c, 5, 2))
+	}
+	return &Net{InputSize: inSize, OutputType: outType, Net: net}
+}
+
+func randomImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(rand.Intn(0x100)),
+				G: uint8(rand.Intn(0x100)),
+				B: uint8(rand.Intn(0x100)),
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
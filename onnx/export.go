@@ -0,0 +1,526 @@
+package onnx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/autorot"
+)
+
+// Names of the graph's input and output tensors.
+const (
+	InputName  = "input"
+	OutputName = "output"
+)
+
+// Export converts a Net into an ONNX model.
+//
+// The model's input is a batch of images in the layout
+// produced by the Net's own preprocessing, i.e. a tensor
+// of shape [N, InputSize, InputSize, 3] with RGB values in
+// [0, 1].
+// Its output is the raw output of the network.
+// Both are described in the model's metadata, along with
+// how to decode the outputs (see Describe).
+//
+// BatchNorm layers normalize with the statistics of each
+// batch, which ONNX runtimes cannot reproduce, so they
+// must be replaced by post_train before exporting.
+// Quantized layers are not supported either.
+func Export(net *autorot.Net) (*Model, error) {
+	b := &builder{
+		graph: &Graph{
+			Name: "autorot",
+			Inputs: []*ValueInfo{{
+				Name:   InputName,
+				Dims:   []int64{0, int64(net.InputSize), int64(net.InputSize), 3},
+				Params: []string{"N"},
+			}},
+		},
+		cur: value{
+			Name:   InputName,
+			Layout: nhwcLayout,
+			Width:  net.InputSize,
+			Height: net.InputSize,
+			Depth:  3,
+		},
+	}
+	if err := b.layers(net.Net); err != nil {
+		return nil, errors.New("export ONNX: " + err.Error())
+	}
+	b.toFlat()
+	if b.cur.Size() != autorot.OutputCount(net.OutputType) {
+		return nil, fmt.Errorf("export ONNX: network has %d outputs but %s needs %d",
+			b.cur.Size(), net.OutputType, autorot.OutputCount(net.OutputType))
+	}
+	b.finish()
+	b.graph.Outputs = []*ValueInfo{{
+		Name:   OutputName,
+		Dims:   []int64{0, int64(b.cur.Size())},
+		Params: []string{"N"},
+	}}
+
+	model := &Model{
+		Graph:     b.graph,
+		DocString: "autorot network; see metadata_props for pre- and post-processing.",
+	}
+	for _, prop := range Describe(net) {
+		model.SetMetadata(prop[0], prop[1])
+	}
+	return model, nil
+}
+
+// Describe produces metadata properties (key-value pairs)
+// which explain how to prepare inputs for a Net and how to
+// interpret its outputs.
+func Describe(net *autorot.Net) [][2]string {
+	size := strconv.Itoa(net.InputSize)
+	normalization := net.Metadata.InputNormalization
+	if normalization == "" {
+		normalization = autorot.InputNormalization
+	}
+	angleConvention := net.Metadata.AngleConvention
+	if angleConvention == "" {
+		angleConvention = autorot.AngleConvention
+	}
+	res := [][2]string{
+		{"autorot.input_size", size},
+		{"autorot.input_mode", net.InputMode.String()},
+		{"autorot.input_fit", describeInputMode(net.InputMode, size)},
+		{"autorot.input_layout", "float32 tensor [N, " + size + ", " + size +
+			", 3]: rows top to bottom, then columns left to right, then R, G, B"},
+		{"autorot.input_normalization", normalization +
+			" (8-bit components are divided by 255)"},
+		{"autorot.output_type", net.OutputType.String()},
+		{"autorot.output_decoding", describeOutputs(net.OutputType)},
+		{"autorot.angle_convention", angleConvention},
+		{"autorot.correction", "to make an image upright, rotate it counter-clockwise " +
+			"by the predicted angle"},
+	}
+	if c := describeCalibration(&net.Calibration); c != "" {
+		res = append(res, [2]string{"autorot.calibration", c})
+	}
+	if net.Metadata.Notes != "" {
+		res = append(res, [2]string{"autorot.notes", net.Metadata.Notes})
+	}
+	if net.Metadata.DatasetHash != "" {
+		res = append(res, [2]string{"autorot.dataset_hash", net.Metadata.DatasetHash})
+	}
+	return res
+}
+
+func describeInputMode(mode autorot.InputMode, size string) string {
+	switch mode {
+	case autorot.CenterCrop:
+		return "crop the largest centered square from the image and scale it to " +
+			size + "x" + size
+	case autorot.Letterboxed:
+		return "scale the whole image to fit in " + size + "x" + size +
+			", centered, with black borders"
+	default:
+		return mode.String()
+	}
+}
+
+func describeOutputs(outType autorot.OutputType) string {
+	switch outType {
+	case autorot.RawAngle:
+		return "output[0] is the predicted angle in radians"
+	case autorot.RightAngles:
+		return "output[k] is the log probability that the image was rotated by k*90 " +
+			"degrees; the predicted angle is k*pi/2 for the largest output and the " +
+			"confidence is its probability"
+	case autorot.ConfidenceAngle:
+		return "output[0] is the predicted angle in radians; output[1] is the " +
+			"predicted error e (1 - cos of the angular error), and the confidence is " +
+			"min(1, max(0, (2 - e) / 2))"
+	default:
+		return outType.String()
+	}
+}
+
+func describeCalibration(c *autorot.Calibration) string {
+	switch c.Method {
+	case autorot.TemperatureScaling:
+		return fmt.Sprintf("temperature scaling with T=%g: for RightAngles, divide the "+
+			"log probabilities by T and re-normalize; otherwise, divide the logit of "+
+			"the confidence by T", c.Temperature)
+	case autorot.IsotonicCalibration:
+		var knots []string
+		for i, x := range c.IsotonicX {
+			knots = append(knots, fmt.Sprintf("%g:%g", x, c.IsotonicY[i]))
+		}
+		return "isotonic: map the confidence through the piecewise-linear function " +
+			"with knots " + strings.Join(knots, ",")
+	default:
+		return ""
+	}
+}
+
+// A layout describes how a tensor is arranged.
+type layout int
+
+const (
+	nhwcLayout layout = iota
+	nchwLayout
+	flatLayout
+)
+
+// A value is a tensor in the graph.
+//
+// Every value has a batch dimension followed either by
+// spatial dimensions or (for flatLayout) by Features.
+type value struct {
+	Name   string
+	Layout layout
+
+	Width    int
+	Height   int
+	Depth    int
+	Features int
+}
+
+// Size returns the number of components per batch item.
+func (v value) Size() int {
+	if v.Layout == flatLayout {
+		return v.Features
+	}
+	return v.Width * v.Height * v.Depth
+}
+
+// A builder adds nodes to a graph, keeping track of the
+// current tensor.
+type builder struct {
+	graph *Graph
+	cur   value
+	count int
+}
+
+func (b *builder) layers(net anynet.Net) error {
+	for _, layer := range net {
+		if err := b.layer(layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *builder) layer(layer anynet.Layer) error {
+	switch layer := layer.(type) {
+	case anynet.Net:
+		return b.layers(layer)
+	case *anyconv.Conv:
+		return b.conv(layer)
+	case *anyconv.Padding:
+		return b.padding(layer)
+	case *anyconv.MaxPool:
+		return b.pool("MaxPool", layer.SpanX, layer.SpanY, layer.InputWidth,
+			layer.InputHeight, layer.InputDepth)
+	case *anyconv.MeanPool:
+		return b.pool("AveragePool", layer.SpanX, layer.SpanY, layer.InputWidth,
+			layer.InputHeight, layer.InputDepth)
+	case *anyconv.Residual:
+		return b.residual(layer)
+	case *anynet.FC:
+		return b.fc(layer)
+	case *anynet.Affine:
+		return b.affine(layer)
+	case anynet.Activation:
+		return b.activation(layer)
+	case *anynet.Dropout:
+		// Dropout does nothing during inference.
+		return nil
+	case *anyconv.BatchNorm:
+		return errors.New("BatchNorm layers must be replaced with post_train first")
+	case *autorot.QuantizedConv, *autorot.QuantizedFC:
+		return errors.New("quantized layers are not supported; export the float32 network")
+	default:
+		return fmt.Errorf("unsupported layer type: %T", layer)
+	}
+}
+
+func (b *builder) conv(c *anyconv.Conv) error {
+	if err := b.toSpatial(c.InputWidth, c.InputHeight, c.InputDepth); err != nil {
+		return err
+	}
+	b.toNCHW()
+
+	// anyconv stores each filter as [height, width, depth],
+	// while ONNX expects [depth, height, width].
+	src := c.Filters.Vector.Data().([]float32)
+	weights := make([]float32, len(src))
+	var srcIdx int
+	for f := 0; f < c.FilterCount; f++ {
+		for y := 0; y < c.FilterHeight; y++ {
+			for x := 0; x < c.FilterWidth; x++ {
+				for d := 0; d < c.InputDepth; d++ {
+					dstIdx := ((f*c.InputDepth+d)*c.FilterHeight+y)*c.FilterWidth + x
+					weights[dstIdx] = src[srcIdx]
+					srcIdx++
+				}
+			}
+		}
+	}
+	w := b.initializer(FloatTensor("", weights, int64(c.FilterCount),
+		int64(c.InputDepth), int64(c.FilterHeight), int64(c.FilterWidth)))
+	bias := b.initializer(FloatTensor("", copyFloats(c.Biases.Vector.Data().([]float32)),
+		int64(c.FilterCount)))
+
+	out := b.node("Conv", []string{b.cur.Name, w, bias},
+		IntsAttr("kernel_shape", int64(c.FilterHeight), int64(c.FilterWidth)),
+		IntsAttr("strides", int64(c.StrideY), int64(c.StrideX)))
+	b.cur = value{
+		Name:   out,
+		Layout: nchwLayout,
+		Width:  1 + (c.InputWidth-c.FilterWidth)/c.StrideX,
+		Height: 1 + (c.InputHeight-c.FilterHeight)/c.StrideY,
+		Depth:  c.FilterCount,
+	}
+	return nil
+}
+
+func (b *builder) padding(p *anyconv.Padding) error {
+	if err := b.toSpatial(p.InputWidth, p.InputHeight, p.InputDepth); err != nil {
+		return err
+	}
+	b.toNCHW()
+	pads := b.initializer(Int64Tensor("", []int64{
+		0, 0, int64(p.PaddingTop), int64(p.PaddingLeft),
+		0, 0, int64(p.PaddingBottom), int64(p.PaddingRight),
+	}, 8))
+	out := b.node("Pad", []string{b.cur.Name, pads})
+	b.cur = value{
+		Name:   out,
+		Layout: nchwLayout,
+		Width:  p.InputWidth + p.PaddingLeft + p.PaddingRight,
+		Height: p.InputHeight + p.PaddingTop + p.PaddingBottom,
+		Depth:  p.InputDepth,
+	}
+	return nil
+}
+
+func (b *builder) pool(opType string, spanX, spanY, width, height, depth int) error {
+	if width%spanX != 0 || height%spanY != 0 {
+		return fmt.Errorf("%s span %dx%d does not divide input %dx%d", opType, spanX,
+			spanY, width, height)
+	}
+	if err := b.toSpatial(width, height, depth); err != nil {
+		return err
+	}
+	b.toNCHW()
+	out := b.node(opType, []string{b.cur.Name},
+		IntsAttr("kernel_shape", int64(spanY), int64(spanX)),
+		IntsAttr("strides", int64(spanY), int64(spanX)))
+	b.cur = value{
+		Name:   out,
+		Layout: nchwLayout,
+		Width:  width / spanX,
+		Height: height / spanY,
+		Depth:  depth,
+	}
+	return nil
+}
+
+func (b *builder) fc(f *anynet.FC) error {
+	b.toFlat()
+	if b.cur.Features != f.InCount {
+		return fmt.Errorf("FC layer expects %d inputs but got %d", f.InCount,
+			b.cur.Features)
+	}
+	w := b.initializer(FloatTensor("", copyFloats(f.Weights.Vector.Data().([]float32)),
+		int64(f.OutCount), int64(f.InCount)))
+	bias := b.initializer(FloatTensor("", copyFloats(f.Biases.Vector.Data().([]float32)),
+		int64(f.OutCount)))
+	out := b.node("Gemm", []string{b.cur.Name, w, bias}, IntAttr("transB", 1))
+	b.cur = value{Name: out, Layout: flatLayout, Features: f.OutCount}
+	return nil
+}
+
+// affine exports a per-channel affine transform, such as
+// the ones which post_train substitutes for BatchNorm.
+func (b *builder) affine(a *anynet.Affine) error {
+	scalers := copyFloats(a.Scalers.Vector.Data().([]float32))
+	biases := copyFloats(a.Biases.Vector.Data().([]float32))
+	n := int64(len(scalers))
+	var dims []int64
+	switch {
+	case n == 1:
+		dims = []int64{1}
+	case b.cur.Layout == nchwLayout && len(scalers) == b.cur.Depth:
+		dims = []int64{n, 1, 1}
+	case b.cur.Layout == nhwcLayout && len(scalers) == b.cur.Depth:
+		dims = []int64{n}
+	case b.cur.Layout == flatLayout && len(scalers) == b.cur.Features:
+		dims = []int64{n}
+	default:
+		return fmt.Errorf("affine layer with %d channels does not match its input", n)
+	}
+	s := b.initializer(FloatTensor("", scalers, dims...))
+	bias := b.initializer(FloatTensor("", biases, dims...))
+	scaled := b.node("Mul", []string{b.cur.Name, s})
+	b.cur.Name = b.node("Add", []string{scaled, bias})
+	return nil
+}
+
+func (b *builder) activation(a anynet.Activation) error {
+	var opType string
+	switch a {
+	case anynet.ReLU:
+		opType = "Relu"
+	case anynet.Tanh:
+		opType = "Tanh"
+	case anynet.Sigmoid:
+		opType = "Sigmoid"
+	case anynet.Sin:
+		opType = "Sin"
+	case anynet.Exp:
+		opType = "Exp"
+	case anynet.LogSoftmax:
+		// The softmax covers all of the components of each
+		// batch item.
+		b.toFlat()
+		b.cur.Name = b.node("LogSoftmax", []string{b.cur.Name}, IntAttr("axis", 1))
+		return nil
+	default:
+		return fmt.Errorf("unsupported activation: %v", a)
+	}
+	b.cur.Name = b.node(opType, []string{b.cur.Name})
+	return nil
+}
+
+func (b *builder) residual(r *anyconv.Residual) error {
+	in := b.cur
+	if err := b.layer(r.Layer); err != nil {
+		return err
+	}
+	main := b.cur
+	b.cur = in
+	if r.Projection != nil {
+		if err := b.layer(r.Projection); err != nil {
+			return err
+		}
+	}
+	if b.cur.Size() != main.Size() {
+		return errors.New("residual branches have different sizes")
+	}
+	switch main.Layout {
+	case nchwLayout:
+		b.toNCHW()
+	case nhwcLayout:
+		b.toNHWC()
+	case flatLayout:
+		b.toFlat()
+	}
+	b.cur = value{
+		Name:     b.node("Add", []string{main.Name, b.cur.Name}),
+		Layout:   main.Layout,
+		Width:    main.Width,
+		Height:   main.Height,
+		Depth:    main.Depth,
+		Features: main.Features,
+	}
+	return nil
+}
+
+// toSpatial ensures that the current value is a feature
+// map of the given shape, reshaping flat values if needed.
+func (b *builder) toSpatial(width, height, depth int) error {
+	if b.cur.Layout == flatLayout {
+		if b.cur.Features != width*height*depth {
+			return fmt.Errorf("cannot reshape %d features to %dx%dx%d", b.cur.Features,
+				width, height, depth)
+		}
+		shape := b.initializer(Int64Tensor("", []int64{-1, int64(height), int64(width),
+			int64(depth)}, 4))
+		b.cur = value{
+			Name:   b.node("Reshape", []string{b.cur.Name, shape}),
+			Layout: nhwcLayout,
+			Width:  width,
+			Height: height,
+			Depth:  depth,
+		}
+		return nil
+	}
+	if b.cur.Width != width || b.cur.Height != height || b.cur.Depth != depth {
+		return fmt.Errorf("layer expects %dx%dx%d input but got %dx%dx%d", width, height,
+			depth, b.cur.Width, b.cur.Height, b.cur.Depth)
+	}
+	return nil
+}
+
+func (b *builder) toNCHW() {
+	if b.cur.Layout == nhwcLayout {
+		b.cur.Name = b.node("Transpose", []string{b.cur.Name}, IntsAttr("perm", 0, 3, 1, 2))
+		b.cur.Layout = nchwLayout
+	}
+}
+
+func (b *builder) toNHWC() {
+	if b.cur.Layout == nchwLayout {
+		b.cur.Name = b.node("Transpose", []string{b.cur.Name}, IntsAttr("perm", 0, 2, 3, 1))
+		b.cur.Layout = nhwcLayout
+	}
+}
+
+// toFlat flattens a feature map in the same order as
+// anynet, i.e. with channels varying fastest.
+func (b *builder) toFlat() {
+	if b.cur.Layout == flatLayout {
+		return
+	}
+	b.toNHWC()
+	size := b.cur.Size()
+	b.cur = value{
+		Name:     b.node("Flatten", []string{b.cur.Name}, IntAttr("axis", 1)),
+		Layout:   flatLayout,
+		Features: size,
+	}
+}
+
+// finish makes the current value the graph's output.
+func (b *builder) finish() {
+	for _, node := range b.graph.Nodes {
+		if node.Outputs[0] == b.cur.Name {
+			node.Outputs[0] = OutputName
+			b.cur.Name = OutputName
+			return
+		}
+	}
+	b.node("Identity", []string{b.cur.Name})
+	b.graph.Nodes[len(b.graph.Nodes)-1].Outputs[0] = OutputName
+	b.cur.Name = OutputName
+}
+
+// node adds a node and returns the name of its output.
+func (b *builder) node(opType string, inputs []string, attrs ...*Attribute) string {
+	name := b.name(strings.ToLower(opType))
+	b.graph.Nodes = append(b.graph.Nodes, &Node{
+		Name:       name,
+		OpType:     opType,
+		Inputs:     inputs,
+		Outputs:    []string{name},
+		Attributes: attrs,
+	})
+	return name
+}
+
+// initializer adds a constant tensor, giving it a unique
+// name, and returns the name.
+func (b *builder) initializer(t *Tensor) string {
+	t.Name = b.name("const")
+	b.graph.Initializers = append(b.graph.Initializers, t)
+	return t.Name
+}
+
+func (b *builder) name(prefix string) string {
+	b.count++
+	return prefix + "_" + strconv.Itoa(b.count)
+}
+
+func copyFloats(data []float32) []float32 {
+	return append([]float32{}, data...)
+}
@@ -0,0 +1,252 @@
+package onnx
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/autorot"
+)
+
+func TestExportConvNet(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	filters := make([]float32, 4*3*3*3)
+	for i := range filters {
+		filters[i] = float32(i)
+	}
+	conv := &anyconv.Conv{
+		FilterCount:  4,
+		FilterWidth:  3,
+		FilterHeight: 3,
+		StrideX:      1,
+		StrideY:      1,
+		InputWidth:   10,
+		InputHeight:  10,
+		InputDepth:   3,
+		Filters:      anydiff.NewVar(anyvec32.MakeVectorData(filters)),
+		Biases:       anydiff.NewVar(c.MakeVector(4)),
+	}
+	net := &autorot.Net{
+		InputSize:  8,
+		OutputType: autorot.RightAngles,
+		Net: anynet.Net{
+			&anyconv.Padding{InputWidth: 8, InputHeight: 8, InputDepth: 3,
+				PaddingTop: 1, PaddingBottom: 1, PaddingLeft: 1, PaddingRight: 1},
+			conv,
+			&anynet.Affine{
+				Scalers: anydiff.NewVar(c.MakeVector(4)),
+				Biases:  anydiff.NewVar(c.MakeVector(4)),
+			},
+			anynet.ReLU,
+			&anyconv.MaxPool{SpanX: 2, SpanY: 2, InputWidth: 8, InputHeight: 8, InputDepth: 4},
+			&anyconv.MeanPool{SpanX: 4, SpanY: 4, InputWidth: 4, InputHeight: 4, InputDepth: 4},
+			&anynet.Dropout{KeepProb: 0.5},
+			anynet.NewFC(c, 4, 4),
+			anynet.LogSoftmax,
+		},
+		Metadata: autorot.NewMetadata(),
+	}
+
+	model, err := Export(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := model.Graph
+	expectedOps := []string{"Transpose", "Pad", "Conv", "Mul", "Add", "Relu", "MaxPool",
+		"AveragePool", "Transpose", "Flatten", "Gemm", "LogSoftmax"}
+	if ops := opTypes(g); !reflect.DeepEqual(ops, expectedOps) {
+		t.Fatalf("expected ops %v but got %v", expectedOps, ops)
+	}
+	testConnected(t, g)
+
+	if perm := g.Nodes[0].Attribute("perm"); perm == nil ||
+		!reflect.DeepEqual(perm.Ints, []int64{0, 3, 1, 2}) {
+		t.Error("bad input transpose")
+	}
+	pads := g.Initializer(g.Nodes[1].Inputs[1])
+	if !reflect.DeepEqual(pads.Int64Data, []int64{0, 0, 1, 1, 0, 0, 1, 1}) {
+		t.Errorf("bad pads: %v", pads.Int64Data)
+	}
+
+	weights := g.Initializer(g.Nodes[2].Inputs[1])
+	if !reflect.DeepEqual(weights.Dims, []int64{4, 3, 3, 3}) {
+		t.Fatalf("bad conv weight shape: %v", weights.Dims)
+	}
+	// Filter 1, depth 2, row 0, column 1 comes from index
+	// ((1*3+0)*3+1)*3+2 in anyconv's layout.
+	if x := weights.FloatData[((1*3+2)*3+0)*3+1]; x != 32 {
+		t.Errorf("bad conv weight ordering: got %f", x)
+	}
+	if scale := g.Initializer(g.Nodes[3].Inputs[1]); !reflect.DeepEqual(scale.Dims,
+		[]int64{4, 1, 1}) {
+		t.Errorf("bad affine shape: %v", scale.Dims)
+	}
+	if gemm := g.Nodes[10]; gemm.Attribute("transB") == nil ||
+		!reflect.DeepEqual(g.Initializer(gemm.Inputs[1]).Dims, []int64{4, 4}) {
+		t.Error("bad Gemm node")
+	}
+	if last := g.Nodes[len(g.Nodes)-1]; last.Outputs[0] != OutputName {
+		t.Error("last node does not produce the output")
+	}
+	if !reflect.DeepEqual(g.Outputs[0].Dims, []int64{0, 4}) {
+		t.Errorf("bad output shape: %v", g.Outputs[0].Dims)
+	}
+
+	for _, key := range []string{"autorot.input_size", "autorot.input_layout",
+		"autorot.input_normalization", "autorot.output_decoding"} {
+		if model.Metadata[key] == "" {
+			t.Errorf("missing metadata: %s", key)
+		}
+	}
+	if model.Metadata["autorot.output_type"] != "RightAngles" {
+		t.Error("bad output type metadata")
+	}
+}
+
+func TestExportFCNet(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	net := &autorot.Net{
+		InputSize:  4,
+		OutputType: autorot.ConfidenceAngle,
+		Net:        anynet.Net{anynet.NewFC(c, 4*4*3, 5), anynet.Tanh, anynet.NewFC(c, 5, 2)},
+	}
+	model, err := Export(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOps := []string{"Flatten", "Gemm", "Tanh", "Gemm"}
+	if ops := opTypes(model.Graph); !reflect.DeepEqual(ops, expectedOps) {
+		t.Fatalf("expected ops %v but got %v", expectedOps, ops)
+	}
+	testConnected(t, model.Graph)
+
+	net.OutputType = autorot.RightAngles
+	if _, err := Export(net); err == nil {
+		t.Error("expected error for mismatched output count")
+	}
+}
+
+func TestExportUnsupported(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	net := &autorot.Net{
+		InputSize:  4,
+		OutputType: autorot.RawAngle,
+		Net: anynet.Net{
+			&anyconv.BatchNorm{
+				InputCount: 3,
+				Scalers:    anydiff.NewVar(c.MakeVector(3)),
+				Biases:     anydiff.NewVar(c.MakeVector(3)),
+			},
+			anynet.NewFC(c, 4*4*3, 1),
+		},
+	}
+	if _, err := Export(net); err == nil {
+		t.Error("expected error for BatchNorm")
+	}
+}
+
+func TestTensorEncoding(t *testing.T) {
+	actual := marshal(FloatTensor("w", []float32{1}, 1))
+	expected := []byte{
+		0x0a, 0x01, 0x01, // dims
+		0x10, 0x01, // data_type
+		0x22, 0x04, 0x00, 0x00, 0x80, 0x3f, // float_data
+		0x42, 0x01, 'w', // name
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %x but got %x", expected, actual)
+	}
+}
+
+func TestModelEncoding(t *testing.T) {
+	model := &Model{Graph: &Graph{Name: "g"}}
+	model.SetMetadata("a", "1")
+	model.SetMetadata("b", "2")
+	model.SetMetadata("a", "3")
+	fields := readFields(t, model.Marshal())
+	if v := fields[1]; len(v) != 1 || v[0].(uint64) != IRVersion {
+		t.Error("bad ir_version")
+	}
+	if len(fields[7]) != 1 {
+		t.Error("missing graph")
+	}
+	opset := readFields(t, fields[8][0].([]byte))
+	if opset[2][0].(uint64) != OpsetVersion {
+		t.Error("bad opset version")
+	}
+	if props := fields[14]; len(props) != 2 {
+		t.Fatalf("expected 2 metadata props but got %d", len(props))
+	}
+	first := readFields(t, fields[14][0].([]byte))
+	if string(first[1][0].([]byte)) != "a" || string(first[2][0].([]byte)) != "3" {
+		t.Error("bad metadata prop")
+	}
+}
+
+func opTypes(g *Graph) []string {
+	var res []string
+	for _, n := range g.Nodes {
+		res = append(res, n.OpType)
+	}
+	return res
+}
+
+// testConnected checks that every node input is produced
+// by an earlier node, an initializer, or the graph input.
+func testConnected(t *testing.T, g *Graph) {
+	available := map[string]bool{InputName: true}
+	for _, init := range g.Initializers {
+		available[init.Name] = true
+	}
+	for _, n := range g.Nodes {
+		for _, in := range n.Inputs {
+			if !available[in] {
+				t.Errorf("node %s: unknown input %s", n.Name, in)
+			}
+		}
+		for _, out := range n.Outputs {
+			available[out] = true
+		}
+	}
+	if !available[OutputName] {
+		t.Error("graph output is never produced")
+	}
+}
+
+// readFields decodes the top level of a protocol buffer
+// message, mapping field numbers to varints or byte
+// strings.
+func readFields(t *testing.T, data []byte) map[int][]interface{} {
+	res := map[int][]interface{}{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatal("bad key")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			x, n := binary.Uvarint(data)
+			if n <= 0 {
+				t.Fatal("bad varint")
+			}
+			data = data[n:]
+			res[field] = append(res[field], x)
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || int(size) > len(data)-n {
+				t.Fatal("bad length")
+			}
+			res[field] = append(res[field], data[n:n+int(size)])
+			data = data[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return res
+}
@@ -0,0 +1,303 @@
+// Package onnx exports autorot networks as ONNX models.
+//
+// It includes a minimal encoder for the subset of the ONNX
+// protocol buffer schema which is needed to describe
+// feed-forward networks, so that no protobuf library is
+// required.
+package onnx
+
+import (
+	"io/ioutil"
+)
+
+// Versions of the ONNX format and operator set which are
+// used by exported models.
+const (
+	IRVersion     = 7
+	OpsetVersion  = 13
+	ProducerName  = "autorot"
+	floatDataType = 1
+	int64DataType = 7
+)
+
+// A Model is an ONNX ModelProto.
+type Model struct {
+	Graph *Graph
+
+	// Metadata is stored as the model's metadata_props.
+	Metadata map[string]string
+
+	// MetadataKeys lists the keys of Metadata in the order
+	// they are written.
+	MetadataKeys []string
+
+	DocString string
+}
+
+// SetMetadata adds or replaces a metadata property.
+func (m *Model) SetMetadata(key, value string) {
+	if m.Metadata == nil {
+		m.Metadata = map[string]string{}
+	}
+	if _, ok := m.Metadata[key]; !ok {
+		m.MetadataKeys = append(m.MetadataKeys, key)
+	}
+	m.Metadata[key] = value
+}
+
+// Marshal encodes the model as an ONNX protocol buffer.
+func (m *Model) Marshal() []byte {
+	return marshal(m)
+}
+
+// Save writes the encoded model to a file.
+func (m *Model) Save(path string) error {
+	return ioutil.WriteFile(path, m.Marshal(), 0644)
+}
+
+func (m *Model) encode(e *encoder) {
+	e.Int(1, IRVersion)
+	e.String(2, ProducerName)
+	if m.DocString != "" {
+		e.String(6, m.DocString)
+	}
+	e.Message(7, m.Graph)
+	e.Message(8, opsetImport{Version: OpsetVersion})
+	for _, key := range m.MetadataKeys {
+		e.Message(14, stringEntry{Key: key, Value: m.Metadata[key]})
+	}
+}
+
+type opsetImport struct {
+	Domain  string
+	Version int64
+}
+
+func (o opsetImport) encode(e *encoder) {
+	e.String(1, o.Domain)
+	e.Int(2, o.Version)
+}
+
+type stringEntry struct {
+	Key   string
+	Value string
+}
+
+func (s stringEntry) encode(e *encoder) {
+	e.String(1, s.Key)
+	e.String(2, s.Value)
+}
+
+// A Graph is an ONNX GraphProto.
+type Graph struct {
+	Name         string
+	Nodes        []*Node
+	Initializers []*Tensor
+	Inputs       []*ValueInfo
+	Outputs      []*ValueInfo
+}
+
+// Initializer finds an initializer by name.
+// It returns nil if none is found.
+func (g *Graph) Initializer(name string) *Tensor {
+	for _, t := range g.Initializers {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func (g *Graph) encode(e *encoder) {
+	for _, n := range g.Nodes {
+		e.Message(1, n)
+	}
+	e.String(2, g.Name)
+	for _, t := range g.Initializers {
+		e.Message(5, t)
+	}
+	for _, v := range g.Inputs {
+		e.Message(11, v)
+	}
+	for _, v := range g.Outputs {
+		e.Message(12, v)
+	}
+}
+
+// A Node is an ONNX NodeProto.
+type Node struct {
+	Name       string
+	OpType     string
+	Inputs     []string
+	Outputs    []string
+	Attributes []*Attribute
+}
+
+// Attribute finds an attribute by name.
+// It returns nil if none is found.
+func (n *Node) Attribute(name string) *Attribute {
+	for _, a := range n.Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func (n *Node) encode(e *encoder) {
+	for _, in := range n.Inputs {
+		e.String(1, in)
+	}
+	for _, out := range n.Outputs {
+		e.String(2, out)
+	}
+	e.String(3, n.Name)
+	e.String(4, n.OpType)
+	for _, a := range n.Attributes {
+		e.Message(5, a)
+	}
+}
+
+// AttributeType is the type of an Attribute.
+type AttributeType int
+
+// These values match ONNX's AttributeProto.AttributeType.
+const (
+	FloatAttribute  AttributeType = 1
+	IntAttribute    AttributeType = 2
+	StringAttribute AttributeType = 3
+	IntsAttribute   AttributeType = 7
+)
+
+// An Attribute is an ONNX AttributeProto.
+//
+// Only the field corresponding to Type is used.
+type Attribute struct {
+	Name  string
+	Type  AttributeType
+	Float float32
+	Int   int64
+	Str   string
+	Ints  []int64
+}
+
+// IntAttr creates an integer attribute.
+func IntAttr(name string, x int64) *Attribute {
+	return &Attribute{Name: name, Type: IntAttribute, Int: x}
+}
+
+// IntsAttr creates an integer list attribute.
+func IntsAttr(name string, xs ...int64) *Attribute {
+	return &Attribute{Name: name, Type: IntsAttribute, Ints: xs}
+}
+
+func (a *Attribute) encode(e *encoder) {
+	e.String(1, a.Name)
+	switch a.Type {
+	case FloatAttribute:
+		e.Float(2, a.Float)
+	case IntAttribute:
+		e.Int(3, a.Int)
+	case StringAttribute:
+		e.String(4, a.Str)
+	case IntsAttribute:
+		e.Ints(8, a.Ints)
+	default:
+		panic("unsupported attribute type")
+	}
+	e.Int(20, int64(a.Type))
+}
+
+// A Tensor is an ONNX TensorProto holding either float32
+// or int64 data.
+type Tensor struct {
+	Name      string
+	Dims      []int64
+	FloatData []float32
+	Int64Data []int64
+}
+
+// FloatTensor creates a float32 tensor.
+func FloatTensor(name string, data []float32, dims ...int64) *Tensor {
+	return &Tensor{Name: name, Dims: dims, FloatData: data}
+}
+
+// Int64Tensor creates an int64 tensor.
+func Int64Tensor(name string, data []int64, dims ...int64) *Tensor {
+	return &Tensor{Name: name, Dims: dims, Int64Data: data}
+}
+
+func (t *Tensor) encode(e *encoder) {
+	e.Ints(1, t.Dims)
+	if t.Int64Data != nil {
+		e.Int(2, int64DataType)
+		e.Ints(7, t.Int64Data)
+	} else {
+		e.Int(2, floatDataType)
+		e.Floats(4, t.FloatData)
+	}
+	e.String(8, t.Name)
+}
+
+// A ValueInfo is an ONNX ValueInfoProto for a float32
+// tensor.
+//
+// Dimensions with a non-empty entry in Params are
+// symbolic, e.g. the batch size.
+type ValueInfo struct {
+	Name   string
+	Dims   []int64
+	Params []string
+}
+
+func (v *ValueInfo) encode(e *encoder) {
+	e.String(1, v.Name)
+	e.Message(2, tensorType{v})
+}
+
+// tensorType encodes the TypeProto of a ValueInfo.
+type tensorType struct {
+	Value *ValueInfo
+}
+
+func (t tensorType) encode(e *encoder) {
+	e.Message(1, tensorTypeInfo{t.Value})
+}
+
+// tensorTypeInfo encodes a TypeProto.Tensor.
+type tensorTypeInfo struct {
+	Value *ValueInfo
+}
+
+func (t tensorTypeInfo) encode(e *encoder) {
+	e.Int(1, floatDataType)
+	e.Message(2, tensorShape{t.Value})
+}
+
+// tensorShape encodes a TensorShapeProto.
+type tensorShape struct {
+	Value *ValueInfo
+}
+
+func (t tensorShape) encode(e *encoder) {
+	for i, dim := range t.Value.Dims {
+		var param string
+		if i < len(t.Value.Params) {
+			param = t.Value.Params[i]
+		}
+		e.Message(1, dimension{Value: dim, Param: param})
+	}
+}
+
+type dimension struct {
+	Value int64
+	Param string
+}
+
+func (d dimension) encode(e *encoder) {
+	if d.Param != "" {
+		e.String(2, d.Param)
+	} else {
+		e.Int(1, d.Value)
+	}
+}
@@ -0,0 +1,99 @@
+package onnx
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed32 = 5
+	wireBytes   = 2
+)
+
+// An encoder builds a protocol buffer message.
+//
+// Only the features needed for ONNX models are supported.
+// Repeated numeric fields are always packed, which every
+// protocol buffer decoder accepts.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) key(field, wireType int) {
+	e.varint(uint64(field<<3 | wireType))
+}
+
+func (e *encoder) varint(x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+// Int writes an int32, int64, or enum field.
+func (e *encoder) Int(field int, x int64) {
+	e.key(field, wireVarint)
+	e.varint(uint64(x))
+}
+
+// Float writes a float field.
+func (e *encoder) Float(field int, x float32) {
+	e.key(field, wireFixed32)
+	e.buf = appendFloat(e.buf, x)
+}
+
+// Bytes writes a bytes field.
+func (e *encoder) Bytes(field int, data []byte) {
+	e.key(field, wireBytes)
+	e.varint(uint64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+// String writes a string field.
+func (e *encoder) String(field int, s string) {
+	e.Bytes(field, []byte(s))
+}
+
+// Message writes an embedded message field.
+func (e *encoder) Message(field int, m message) {
+	var sub encoder
+	m.encode(&sub)
+	e.Bytes(field, sub.buf)
+}
+
+// Ints writes a packed repeated integer field.
+func (e *encoder) Ints(field int, xs []int64) {
+	var packed encoder
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	e.Bytes(field, packed.buf)
+}
+
+// Floats writes a packed repeated float field.
+func (e *encoder) Floats(field int, xs []float32) {
+	packed := make([]byte, 0, len(xs)*4)
+	for _, x := range xs {
+		packed = appendFloat(packed, x)
+	}
+	e.Bytes(field, packed)
+}
+
+func appendFloat(buf []byte, x float32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(x))
+	return append(buf, tmp[:]...)
+}
+
+// A message can be encoded as a protocol buffer.
+type message interface {
+	encode(e *encoder)
+}
+
+// marshal encodes a message.
+func marshal(m message) []byte {
+	var e encoder
+	m.encode(&e)
+	return e.buf
+}
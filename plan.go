@@ -0,0 +1,722 @@
+package autorot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+// Block sizes for matrix multiplications.
+const (
+	gemmRowBlock = 64
+	gemmBlockK   = 128
+	gemmBlockN   = 256
+)
+
+// A Plan is an optimized, immutable version of a network
+// for inference on the CPU.
+//
+// Convolutions are computed with im2col and a blocked
+// matrix multiplication, work is split across goroutines,
+// and intermediate buffers are reused between calls.
+// Layers without an optimized implementation, such as
+// BatchNorm, are run through anynet as usual.
+//
+// A Plan copies the parameters of the network, so it must
+// be compiled again if the network is modified.
+// Unlike a Net, a Plan is safe for concurrent use.
+type Plan struct {
+	InCount  int
+	OutCount int
+
+	ops        []planOp
+	numBuffers int
+	pool       sync.Pool
+}
+
+// CompilePlan compiles a network which takes inCount
+// inputs per batch item.
+func CompilePlan(net anynet.Net, inCount int) (*Plan, error) {
+	res := &Plan{InCount: inCount}
+	c := &planCompiler{}
+	ops, outCount, err := c.compile(net, inCount)
+	if err != nil {
+		return nil, errors.New("compile plan: " + err.Error())
+	}
+	res.ops = ops
+	res.OutCount = outCount
+	res.numBuffers = c.numBuffers
+	return res, nil
+}
+
+// Apply applies the plan to a batch of n inputs.
+func (p *Plan) Apply(in []float32, n int) []float32 {
+	if len(in) != n*p.InCount {
+		panic("incorrect input size")
+	}
+	ws, ok := p.pool.Get().(*workspace)
+	if !ok {
+		ws = &workspace{outputs: make([][]float32, p.numBuffers)}
+	}
+	out := applyOps(p.ops, ws, in, n)
+	res := append([]float32{}, out...)
+	p.pool.Put(ws)
+	return res
+}
+
+func applyOps(ops []planOp, ws *workspace, in []float32, n int) []float32 {
+	for _, op := range ops {
+		in = op.Apply(ws, in, n)
+	}
+	return in
+}
+
+// A workspace stores the buffers for one call to Apply.
+//
+// Every op has its own output buffer, and each worker
+// goroutine has its own scratch buffer.
+type workspace struct {
+	outputs [][]float32
+
+	scratchLock sync.Mutex
+	scratch     [][]float32
+}
+
+func (w *workspace) Output(idx, size int) []float32 {
+	if cap(w.outputs[idx]) < size {
+		w.outputs[idx] = make([]float32, size)
+	}
+	return w.outputs[idx][:size]
+}
+
+func (w *workspace) Scratch(worker, size int) []float32 {
+	w.scratchLock.Lock()
+	defer w.scratchLock.Unlock()
+	for len(w.scratch) <= worker {
+		w.scratch = append(w.scratch, nil)
+	}
+	if cap(w.scratch[worker]) < size {
+		w.scratch[worker] = make([]float32, size)
+	}
+	return w.scratch[worker][:size]
+}
+
+// A planOp is one step of a Plan.
+type planOp interface {
+	// Apply computes the op for a batch and returns its
+	// output, which is stored in the workspace.
+	Apply(ws *workspace, in []float32, n int) []float32
+}
+
+type planCompiler struct {
+	numBuffers int
+}
+
+func (p *planCompiler) buffer() int {
+	p.numBuffers++
+	return p.numBuffers - 1
+}
+
+func (p *planCompiler) compile(net anynet.Net, inCount int) ([]planOp, int, error) {
+	var ops []planOp
+	for _, layer := range net {
+		layerOps, outCount, err := p.compileLayer(layer, inCount)
+		if err != nil {
+			return nil, 0, err
+		}
+		ops = append(ops, layerOps...)
+		inCount = outCount
+	}
+	return ops, inCount, nil
+}
+
+func (p *planCompiler) compileLayer(layer anynet.Layer, inCount int) ([]planOp, int, error) {
+	switch layer := layer.(type) {
+	case anynet.Net:
+		return p.compile(layer, inCount)
+	case *anyconv.Conv:
+		op, err := p.conv(layer, inCount)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []planOp{op}, op.OutWidth * op.OutHeight * op.FilterCount, nil
+	case *anyconv.Padding:
+		if layer.InputWidth*layer.InputHeight*layer.InputDepth != inCount {
+			return nil, 0, errors.New("padding: input size mismatch")
+		}
+		op := &paddingOp{Buffer: p.buffer(), Padding: *layer}
+		outCount := (layer.InputWidth + layer.PaddingLeft + layer.PaddingRight) *
+			(layer.InputHeight + layer.PaddingTop + layer.PaddingBottom) * layer.InputDepth
+		return []planOp{op}, outCount, nil
+	case *anyconv.MaxPool:
+		return p.pool(layer, true, layer.SpanX, layer.SpanY, layer.InputWidth,
+			layer.InputHeight, layer.InputDepth, inCount)
+	case *anyconv.MeanPool:
+		return p.pool(layer, false, layer.SpanX, layer.SpanY, layer.InputWidth,
+			layer.InputHeight, layer.InputDepth, inCount)
+	case *anynet.FC:
+		if layer.InCount != inCount {
+			return nil, 0, errors.New("FC: input size mismatch")
+		}
+		op := &fcOp{
+			Buffer:   p.buffer(),
+			InCount:  layer.InCount,
+			OutCount: layer.OutCount,
+			Weights: transposeMatrix(layer.Weights.Vector.Data().([]float32),
+				layer.OutCount, layer.InCount),
+			Biases: copyFloats(layer.Biases.Vector.Data().([]float32)),
+		}
+		return []planOp{op}, layer.OutCount, nil
+	case *anynet.Affine:
+		op := &affineOp{
+			Buffer:  p.buffer(),
+			Scalers: copyFloats(layer.Scalers.Vector.Data().([]float32)),
+			Biases:  copyFloats(layer.Biases.Vector.Data().([]float32)),
+		}
+		if len(op.Scalers) == 0 || inCount%len(op.Scalers) != 0 {
+			return nil, 0, errors.New("affine: input size mismatch")
+		}
+		return []planOp{op}, inCount, nil
+	case anynet.Activation:
+		switch layer {
+		case anynet.ReLU, anynet.Tanh, anynet.Sigmoid, anynet.Exp, anynet.Sin,
+			anynet.LogSoftmax:
+			return []planOp{&activationOp{Buffer: p.buffer(), Activation: layer}}, inCount,
+				nil
+		}
+	case *anynet.Dropout:
+		if !layer.Enabled {
+			return nil, inCount, nil
+		}
+	case *anyconv.Residual:
+		return p.residual(layer, inCount)
+	}
+	return p.fallback(layer, inCount)
+}
+
+func (p *planCompiler) conv(c *anyconv.Conv, inCount int) (*convOp, error) {
+	if c.InputWidth*c.InputHeight*c.InputDepth != inCount {
+		return nil, errors.New("conv: input size mismatch")
+	}
+	patchSize := c.FilterWidth * c.FilterHeight * c.InputDepth
+	return &convOp{
+		Buffer:       p.buffer(),
+		InputWidth:   c.InputWidth,
+		InputHeight:  c.InputHeight,
+		InputDepth:   c.InputDepth,
+		FilterWidth:  c.FilterWidth,
+		FilterHeight: c.FilterHeight,
+		FilterCount:  c.FilterCount,
+		StrideX:      c.StrideX,
+		StrideY:      c.StrideY,
+		OutWidth:     1 + (c.InputWidth-c.FilterWidth)/c.StrideX,
+		OutHeight:    1 + (c.InputHeight-c.FilterHeight)/c.StrideY,
+		Weights: transposeMatrix(c.Filters.Vector.Data().([]float32), c.FilterCount,
+			patchSize),
+		Biases: copyFloats(c.Biases.Vector.Data().([]float32)),
+	}, nil
+}
+
+func (p *planCompiler) pool(layer anynet.Layer, isMax bool, spanX, spanY, width, height,
+	depth, inCount int) ([]planOp, int, error) {
+	if width*height*depth != inCount {
+		return nil, 0, errors.New("pool: input size mismatch")
+	}
+	if width%spanX != 0 || height%spanY != 0 {
+		// Partial windows are left to anyconv.
+		return p.fallback(layer, inCount)
+	}
+	op := &poolOp{
+		Buffer:      p.buffer(),
+		Max:         isMax,
+		SpanX:       spanX,
+		SpanY:       spanY,
+		InputWidth:  width,
+		InputHeight: height,
+		InputDepth:  depth,
+	}
+	return []planOp{op}, (width / spanX) * (height / spanY) * depth, nil
+}
+
+func (p *planCompiler) residual(r *anyconv.Residual, inCount int) ([]planOp, int, error) {
+	layerOps, outCount, err := p.compileLayer(r.Layer, inCount)
+	if err != nil {
+		return nil, 0, err
+	}
+	op := &residualOp{Buffer: p.buffer(), Layer: layerOps}
+	projCount := inCount
+	if r.Projection != nil {
+		op.Projection, projCount, err = p.compileLayer(r.Projection, inCount)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if projCount != outCount {
+		return nil, 0, errors.New("residual: branch size mismatch")
+	}
+	return []planOp{op}, outCount, nil
+}
+
+func (p *planCompiler) fallback(layer anynet.Layer, inCount int) ([]planOp, int, error) {
+	zeroIn := anydiff.NewConst(anyvec32.MakeVector(inCount))
+	outCount := layer.Apply(zeroIn, 1).Output().Len()
+	return []planOp{&fallbackOp{Buffer: p.buffer(), Layer: layer}}, outCount, nil
+}
+
+// A convOp applies a convolution by copying each output's
+// input patch into a matrix row (im2col) and multiplying
+// the matrix by the filters.
+type convOp struct {
+	Buffer int
+
+	InputWidth   int
+	InputHeight  int
+	InputDepth   int
+	FilterWidth  int
+	FilterHeight int
+	FilterCount  int
+	StrideX      int
+	StrideY      int
+	OutWidth     int
+	OutHeight    int
+
+	// Weights is a patchSize x FilterCount matrix.
+	Weights []float32
+	Biases  []float32
+}
+
+func (c *convOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	inSize := c.InputWidth * c.InputHeight * c.InputDepth
+	numRows := c.OutWidth * c.OutHeight
+	outSize := numRows * c.FilterCount
+	out := ws.Output(c.Buffer, n*outSize)
+
+	rowSize := c.FilterWidth * c.InputDepth
+	patchSize := c.FilterHeight * rowSize
+	tasksPerItem := (numRows + gemmRowBlock - 1) / gemmRowBlock
+	parallelFor(n*tasksPerItem, func(worker, task int) {
+		item := task / tasksPerItem
+		start := (task % tasksPerItem) * gemmRowBlock
+		end := start + gemmRowBlock
+		if end > numRows {
+			end = numRows
+		}
+		itemIn := in[item*inSize : (item+1)*inSize]
+		patches := ws.Scratch(worker, (end-start)*patchSize)
+		for row := start; row < end; row++ {
+			y := (row / c.OutWidth) * c.StrideY
+			x := (row % c.OutWidth) * c.StrideX
+			patch := patches[(row-start)*patchSize : (row-start+1)*patchSize]
+			for fy := 0; fy < c.FilterHeight; fy++ {
+				inIdx := ((y+fy)*c.InputWidth + x) * c.InputDepth
+				copy(patch[fy*rowSize:(fy+1)*rowSize], itemIn[inIdx:inIdx+rowSize])
+			}
+		}
+		itemOut := out[item*outSize+start*c.FilterCount : item*outSize+end*c.FilterCount]
+		fillRows(itemOut, c.Biases)
+		sgemm(end-start, c.FilterCount, patchSize, patches, c.Weights, itemOut)
+	})
+	return out
+}
+
+// An fcOp is a fully-connected layer.
+type fcOp struct {
+	Buffer   int
+	InCount  int
+	OutCount int
+
+	// Weights is an InCount x OutCount matrix.
+	Weights []float32
+	Biases  []float32
+}
+
+func (f *fcOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	out := ws.Output(f.Buffer, n*f.OutCount)
+	numTasks := (n + gemmRowBlock - 1) / gemmRowBlock
+	parallelFor(numTasks, func(worker, task int) {
+		start := task * gemmRowBlock
+		end := start + gemmRowBlock
+		if end > n {
+			end = n
+		}
+		taskOut := out[start*f.OutCount : end*f.OutCount]
+		fillRows(taskOut, f.Biases)
+		sgemm(end-start, f.OutCount, f.InCount, in[start*f.InCount:end*f.InCount],
+			f.Weights, taskOut)
+	})
+	return out
+}
+
+type paddingOp struct {
+	Buffer  int
+	Padding anyconv.Padding
+}
+
+func (p *paddingOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	l := &p.Padding
+	outWidth := l.InputWidth + l.PaddingLeft + l.PaddingRight
+	outHeight := l.InputHeight + l.PaddingTop + l.PaddingBottom
+	inRow := l.InputWidth * l.InputDepth
+	outRow := outWidth * l.InputDepth
+	inSize := inRow * l.InputHeight
+	outSize := outRow * outHeight
+	out := ws.Output(p.Buffer, n*outSize)
+	for i := range out {
+		out[i] = 0
+	}
+	for item := 0; item < n; item++ {
+		for y := 0; y < l.InputHeight; y++ {
+			src := in[item*inSize+y*inRow : item*inSize+(y+1)*inRow]
+			dstIdx := item*outSize + (y+l.PaddingTop)*outRow + l.PaddingLeft*l.InputDepth
+			copy(out[dstIdx:dstIdx+inRow], src)
+		}
+	}
+	return out
+}
+
+// A poolOp is a max or mean pooling layer whose spans
+// evenly divide its input.
+type poolOp struct {
+	Buffer int
+	Max    bool
+
+	SpanX       int
+	SpanY       int
+	InputWidth  int
+	InputHeight int
+	InputDepth  int
+}
+
+func (p *poolOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	outWidth := p.InputWidth / p.SpanX
+	outHeight := p.InputHeight / p.SpanY
+	depth := p.InputDepth
+	inSize := p.InputWidth * p.InputHeight * depth
+	outSize := outWidth * outHeight * depth
+	out := ws.Output(p.Buffer, n*outSize)
+	scale := 1 / float32(p.SpanX*p.SpanY)
+	parallelFor(n, func(worker, item int) {
+		itemIn := in[item*inSize : (item+1)*inSize]
+		itemOut := out[item*outSize : (item+1)*outSize]
+		for oy := 0; oy < outHeight; oy++ {
+			for ox := 0; ox < outWidth; ox++ {
+				dst := itemOut[(oy*outWidth+ox)*depth : (oy*outWidth+ox+1)*depth]
+				for d := range dst {
+					if p.Max {
+						dst[d] = float32(math.Inf(-1))
+					} else {
+						dst[d] = 0
+					}
+				}
+				for y := oy * p.SpanY; y < (oy+1)*p.SpanY; y++ {
+					for x := ox * p.SpanX; x < (ox+1)*p.SpanX; x++ {
+						src := itemIn[(y*p.InputWidth+x)*depth : (y*p.InputWidth+x+1)*depth]
+						for d, v := range src {
+							if p.Max {
+								if v > dst[d] {
+									dst[d] = v
+								}
+							} else {
+								dst[d] += v
+							}
+						}
+					}
+				}
+				if !p.Max {
+					for d := range dst {
+						dst[d] *= scale
+					}
+				}
+			}
+		}
+	})
+	return out
+}
+
+// An affineOp scales and shifts its inputs, repeating the
+// scalers and biases like anynet.Affine.
+type affineOp struct {
+	Buffer  int
+	Scalers []float32
+	Biases  []float32
+}
+
+func (a *affineOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	out := ws.Output(a.Buffer, len(in))
+	chunk := len(a.Scalers)
+	for i := 0; i < len(in); i += chunk {
+		src := in[i : i+chunk]
+		dst := out[i : i+chunk]
+		for j, x := range src {
+			dst[j] = x*a.Scalers[j] + a.Biases[j]
+		}
+	}
+	return out
+}
+
+type activationOp struct {
+	Buffer     int
+	Activation anynet.Activation
+}
+
+func (a *activationOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	out := ws.Output(a.Buffer, len(in))
+	switch a.Activation {
+	case anynet.ReLU:
+		for i, x := range in {
+			if x > 0 {
+				out[i] = x
+			} else {
+				out[i] = 0
+			}
+		}
+	case anynet.Tanh:
+		for i, x := range in {
+			out[i] = float32(math.Tanh(float64(x)))
+		}
+	case anynet.Sigmoid:
+		for i, x := range in {
+			out[i] = float32(1 / (1 + math.Exp(-float64(x))))
+		}
+	case anynet.Exp:
+		for i, x := range in {
+			out[i] = float32(math.Exp(float64(x)))
+		}
+	case anynet.Sin:
+		for i, x := range in {
+			out[i] = float32(math.Sin(float64(x)))
+		}
+	case anynet.LogSoftmax:
+		if n == 0 {
+			break
+		}
+		size := len(in) / n
+		for item := 0; item < n; item++ {
+			logSoftmax(in[item*size:(item+1)*size], out[item*size:(item+1)*size])
+		}
+	}
+	return out
+}
+
+// A residualOp adds the output of a sub-network to its
+// input (or to a projection of its input).
+type residualOp struct {
+	Buffer     int
+	Layer      []planOp
+	Projection []planOp
+}
+
+func (r *residualOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	layerOut := applyOps(r.Layer, ws, in, n)
+	projOut := applyOps(r.Projection, ws, in, n)
+	out := ws.Output(r.Buffer, len(layerOut))
+	for i, x := range layerOut {
+		out[i] = x + projOut[i]
+	}
+	return out
+}
+
+// A fallbackOp runs a layer with anynet.
+type fallbackOp struct {
+	Buffer int
+	Layer  anynet.Layer
+
+	// lock protects the layer, which may not support
+	// concurrent use.
+	lock sync.Mutex
+}
+
+func (f *fallbackOp) Apply(ws *workspace, in []float32, n int) []float32 {
+	inVec := anydiff.NewConst(anyvec32.MakeVectorData(copyFloats(in)))
+	f.lock.Lock()
+	res := f.Layer.Apply(inVec, n).Output().Data().([]float32)
+	f.lock.Unlock()
+	out := ws.Output(f.Buffer, len(res))
+	copy(out, res)
+	return out
+}
+
+// parallelFor calls f for every index in [0, count),
+// spreading the calls across goroutines.
+// Each goroutine has a distinct worker index, which is
+// less than parallelWorkers(count).
+func parallelFor(count int, f func(worker, idx int)) {
+	numWorkers := parallelWorkers(count)
+	if numWorkers <= 1 {
+		for i := 0; i < count; i++ {
+			f(0, i)
+		}
+		return
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				idx := int(atomic.AddInt64(&next, 1))
+				if idx >= count {
+					return
+				}
+				f(worker, idx)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// parallelWorkers returns the number of goroutines that
+// parallelFor uses for count indices.
+func parallelWorkers(count int) int {
+	numWorkers := runtime.GOMAXPROCS(0)
+	if numWorkers > count {
+		numWorkers = count
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	return numWorkers
+}
+
+// sgemm adds the product of an m x k matrix a and a k x n
+// matrix b to an m x n matrix c.
+// All matrices are row-major.
+//
+// The loops are blocked so that the rows of b which are
+// being used stay in the cache.
+func sgemm(m, n, k int, a, b, c []float32) {
+	for p0 := 0; p0 < k; p0 += gemmBlockK {
+		p1 := p0 + gemmBlockK
+		if p1 > k {
+			p1 = k
+		}
+		for j0 := 0; j0 < n; j0 += gemmBlockN {
+			j1 := j0 + gemmBlockN
+			if j1 > n {
+				j1 = n
+			}
+			for i := 0; i < m; i++ {
+				aRow := a[i*k+p0 : i*k+p1]
+				cRow := c[i*n+j0 : i*n+j1]
+				for p, x := range aRow {
+					if x == 0 {
+						// Common after ReLUs and padding.
+						continue
+					}
+					bRow := b[(p0+p)*n+j0 : (p0+p)*n+j1]
+					axpy(x, bRow, cRow)
+				}
+			}
+		}
+	}
+}
+
+// axpy adds x*v to dst.
+func axpy(x float32, v, dst []float32) {
+	dst = dst[:len(v)]
+	i := 0
+	for ; i+4 <= len(v); i += 4 {
+		dst[i] += x * v[i]
+		dst[i+1] += x * v[i+1]
+		dst[i+2] += x * v[i+2]
+		dst[i+3] += x * v[i+3]
+	}
+	for ; i < len(v); i++ {
+		dst[i] += x * v[i]
+	}
+}
+
+// fillRows sets every row of a matrix to a vector.
+func fillRows(m, row []float32) {
+	for i := 0; i < len(m); i += len(row) {
+		copy(m[i:i+len(row)], row)
+	}
+}
+
+func transposeMatrix(m []float32, rows, cols int) []float32 {
+	res := make([]float32, len(m))
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			res[j*rows+i] = m[i*cols+j]
+		}
+	}
+	return res
+}
+
+func logSoftmax(in, out []float32) {
+	maxVal := math.Inf(-1)
+	for _, x := range in {
+		maxVal = math.Max(maxVal, float64(x))
+	}
+	var sum float64
+	for _, x := range in {
+		sum += math.Exp(float64(x) - maxVal)
+	}
+	logSum := maxVal + math.Log(sum)
+	for i, x := range in {
+		out[i] = float32(float64(x) - logSum)
+	}
+}
+
+func copyFloats(data []float32) []float32 {
+	return append([]float32{}, data...)
+}
+
+// CompilePlan compiles a Plan for the network, which is
+// used by all subsequent evaluations.
+// See Plan for details.
+//
+// The plan is a snapshot of the current parameters, so it
+// should not be used while training.
+func (n *Net) CompilePlan() error {
+	plan, err := CompilePlan(n.Net, n.InputSize*n.InputSize*3)
+	if err != nil {
+		return err
+	}
+	if plan.OutCount != OutputCount(n.OutputType) {
+		return fmt.Errorf("compile plan: expected %d outputs but got %d",
+			OutputCount(n.OutputType), plan.OutCount)
+	}
+	n.plan = plan
+	return nil
+}
+
+// CompileEvaluator compiles a Plan for every network in
+// an Evaluator (see Net.CompilePlan).
+func CompileEvaluator(e Evaluator) error {
+	switch e := e.(type) {
+	case *Net:
+		return e.CompilePlan()
+	case *Ensemble:
+		for _, net := range e.Nets {
+			if err := net.CompilePlan(); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("compile plan: unsupported type %T", e)
+	}
+}
+
+// copyPlans gives the networks of a cloned Evaluator the
+// plans of the original, since plans can be shared.
+func copyPlans(src, dst Evaluator) {
+	switch src := src.(type) {
+	case *Net:
+		dst.(*Net).plan = src.plan
+	case *Ensemble:
+		for i, net := range src.Nets {
+			dst.(*Ensemble).Nets[i].plan = net.plan
+		}
+	}
+}
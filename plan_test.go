@@ -0,0 +1,250 @@
+package autorot
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec/anyvec32"
+)
+
+func TestPlanOutputs(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	net := anynet.Net{
+		&anyconv.Padding{InputWidth: 12, InputHeight: 12, InputDepth: 3,
+			PaddingTop: 1, PaddingBottom: 2, PaddingLeft: 1, PaddingRight: 0},
+		testConv(12, 14, 3, 8, 3, 3, 1, 1),
+		testAffine(8),
+		anynet.ReLU,
+		&anyconv.Residual{
+			Layer: anynet.Net{
+				&anyconv.Padding{InputWidth: 10, InputHeight: 12, InputDepth: 8,
+					PaddingTop: 1, PaddingBottom: 1, PaddingLeft: 1, PaddingRight: 1},
+				testConv(12, 14, 8, 8, 3, 3, 1, 1),
+				anynet.Tanh,
+			},
+		},
+		&anyconv.MaxPool{SpanX: 2, SpanY: 2, InputWidth: 10, InputHeight: 12, InputDepth: 8},
+		testConv(5, 6, 8, 6, 3, 2, 2, 2),
+		&anyconv.BatchNorm{
+			InputCount: 6,
+			Scalers:    anydiff.NewVar(randomVector(6)),
+			Biases:     anydiff.NewVar(randomVector(6)),
+		},
+		anynet.Sigmoid,
+		&anyconv.MeanPool{SpanX: 2, SpanY: 3, InputWidth: 2, InputHeight: 3, InputDepth: 6},
+		anynet.NewFC(c, 6, 5),
+		&anynet.Dropout{KeepProb: 0.5},
+		anynet.Tanh,
+		anynet.NewFC(c, 5, 4),
+		anynet.LogSoftmax,
+	}
+	plan, err := CompilePlan(net, 12*12*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.OutCount != 4 {
+		t.Fatalf("expected 4 outputs but got %d", plan.OutCount)
+	}
+	for _, batch := range []int{1, 3} {
+		in := randomVector(batch * 12 * 12 * 3)
+		expected := net.Apply(anydiff.NewConst(in), batch).Output().Data().([]float32)
+		for i := 0; i < 2; i++ {
+			// The second pass reuses the buffers.
+			actual := plan.Apply(in.Data().([]float32), batch)
+			if len(actual) != len(expected) {
+				t.Fatalf("expected %d outputs but got %d", len(expected), len(actual))
+			}
+			for j, x := range expected {
+				if math.Abs(float64(x-actual[j])) > 1e-4 {
+					t.Errorf("batch %d output %d: expected %f but got %f", batch, j, x,
+						actual[j])
+				}
+			}
+		}
+	}
+
+	if _, err := CompilePlan(net, 11*12*3); err == nil {
+		t.Error("expected error for bad input size")
+	}
+}
+
+func TestNetCompilePlan(t *testing.T) {
+	imgs := []image.Image{randomImage(6, 6), randomImage(9, 7)}
+	net := testNet(6, RightAngles)
+	expected := net.RightAngleProbs(imgs)
+	if err := net.CompilePlan(); err != nil {
+		t.Fatal(err)
+	}
+	actual := net.RightAngleProbs(imgs)
+	for i, probs := range expected {
+		for j, p := range probs {
+			if math.Abs(p-actual[i][j]) > 1e-5 {
+				t.Errorf("image %d prob %d: expected %f but got %f", i, j, p, actual[i][j])
+			}
+		}
+	}
+	clone, err := CloneEvaluator(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.(*Net).plan != net.plan {
+		t.Error("clone should share the plan")
+	}
+
+	net.OutputType = RawAngle
+	if err := net.CompilePlan(); err == nil {
+		t.Error("expected error for mismatched output type")
+	}
+}
+
+func TestPlanConcurrent(t *testing.T) {
+	c := anyvec32.CurrentCreator()
+	net := anynet.Net{
+		&anyconv.Padding{InputWidth: 8, InputHeight: 8, InputDepth: 3,
+			PaddingTop: 1, PaddingBottom: 1, PaddingLeft: 1, PaddingRight: 1},
+		testConv(10, 10, 3, 6, 3, 3, 1, 1),
+		testAffine(6),
+		anynet.ReLU,
+		&scratchLayer{},
+		&anyconv.MaxPool{SpanX: 2, SpanY: 2, InputWidth: 8, InputHeight: 8, InputDepth: 6},
+		anynet.NewFC(c, 4*4*6, 4),
+		anynet.LogSoftmax,
+	}
+	plan, err := CompilePlan(net, 8*8*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numGoroutines = 8
+	var inputs, expected [][]float32
+	for i := 0; i < numGoroutines; i++ {
+		batch := 1 + i%3
+		in := randomVector(batch * plan.InCount).Data().([]float32)
+		inputs = append(inputs, in)
+		expected = append(expected, plan.Apply(in, batch))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			batch := 1 + i%3
+			for j := 0; j < 20; j++ {
+				actual := plan.Apply(inputs[i], batch)
+				for k, x := range expected[i] {
+					if actual[k] != x {
+						errs <- fmt.Sprintf("goroutine %d: output %d: expected %f but got %f",
+							i, k, x, actual[k])
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkPlan(b *testing.B) {
+	const inputSize = 128
+	const batchSize = 8
+	net := benchmarkBackbone(inputSize)
+	in := randomVector(batchSize * inputSize * inputSize * 3)
+	b.Run("Anynet", func(b *testing.B) {
+		inConst := anydiff.NewConst(in)
+		for i := 0; i < b.N; i++ {
+			net.Apply(inConst, batchSize)
+		}
+	})
+	b.Run("Plan", func(b *testing.B) {
+		plan, err := CompilePlan(net, inputSize*inputSize*3)
+		if err != nil {
+			b.Fatal(err)
+		}
+		inData := in.Data().([]float32)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			plan.Apply(inData, batchSize)
+		}
+	})
+}
+
+// benchmarkBackbone creates a post-trained network similar
+// to the "small" architecture of the create command.
+func benchmarkBackbone(inputSize int) anynet.Net {
+	var net anynet.Net
+	size, depth := inputSize, 3
+	for _, filters := range []int{16, 32, 64, 128, 256} {
+		for i := 0; i < 2; i++ {
+			net = append(net,
+				&anyconv.Padding{InputWidth: size, InputHeight: size, InputDepth: depth,
+					PaddingTop: 1, PaddingBottom: 1, PaddingLeft: 1, PaddingRight: 1},
+				testConv(size+2, size+2, depth, filters, 3, 3, 1, 1),
+				testAffine(filters),
+				anynet.ReLU,
+			)
+			depth = filters
+		}
+		net = append(net, &anyconv.MaxPool{SpanX: 2, SpanY: 2, InputWidth: size,
+			InputHeight: size, InputDepth: depth})
+		size /= 2
+	}
+	return append(net,
+		&anyconv.MeanPool{SpanX: size, SpanY: size, InputWidth: size, InputHeight: size,
+			InputDepth: depth},
+		anynet.NewFC(anyvec32.CurrentCreator(), depth, 4),
+		anynet.LogSoftmax,
+	)
+}
+
+func testConv(width, height, depth, filters, filterWidth, filterHeight, strideX,
+	strideY int) *anyconv.Conv {
+	filterSize := filterWidth * filterHeight * depth
+	weights := randomVector(filters * filterSize)
+	weights.Scale(float32(1 / math.Sqrt(float64(filterSize))))
+	return &anyconv.Conv{
+		FilterCount:  filters,
+		FilterWidth:  filterWidth,
+		FilterHeight: filterHeight,
+		StrideX:      strideX,
+		StrideY:      strideY,
+		InputWidth:   width,
+		InputHeight:  height,
+		InputDepth:   depth,
+		Filters:      anydiff.NewVar(weights),
+		Biases:       anydiff.NewVar(randomVector(filters)),
+	}
+}
+
+// scratchLayer doubles its inputs using a buffer which is
+// shared between calls, so it is not safe for concurrent
+// use and must go through a plan's fallback path.
+type scratchLayer struct {
+	buffer []float32
+}
+
+func (s *scratchLayer) Apply(in anydiff.Res, n int) anydiff.Res {
+	s.buffer = append(s.buffer[:0], in.Output().Data().([]float32)...)
+	for i := range s.buffer {
+		s.buffer[i] *= 2
+	}
+	return anydiff.NewConst(in.Output().Creator().MakeVectorData(s.buffer))
+}
+
+func testAffine(depth int) *anynet.Affine {
+	return &anynet.Affine{
+		Scalers: anydiff.NewVar(randomVector(depth)),
+		Biases:  anydiff.NewVar(randomVector(depth)),
+	}
+}
@@ -2,15 +2,33 @@
 // a neural network.
 // As part of doing this, it converts batch normalization
 // layers into affine transforms.
+//
+// Samples are chosen with a fixed seed and rotated by an
+// even mix of right angles, so that the result is
+// deterministic.
+// Before saving, the original and post-trained networks
+// are compared on held-out images.
+// The original network still normalizes with the
+// statistics of each batch, so single outputs can move a
+// fair amount even when post-training worked.
+// The network is therefore only rejected if the two nets
+// disagree on too many right angles (-min-agreement) or
+// their mean deviation exceeds -tolerance; a large max
+// deviation only produces a warning.
+// If a network which evaluates well is rejected, compare
+// the logged numbers against a few known-good runs and
+// loosen the flags to just above what they report.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"log"
 	"math/rand"
 	"os"
-	"time"
 
 	"github.com/unixpickle/anynet/anyconv"
 	"github.com/unixpickle/anynet/anyff"
@@ -27,12 +45,27 @@ func main() {
 
 	var batchSize int
 	var sampleCount int
+	var heldOutCount int
+	var compareBatch int
+	var seed int64
+	var tolerance float64
+	var minAgreement float64
 
 	flag.StringVar(&imgDir, "samples", "", "sample directory")
 	flag.StringVar(&inNet, "in", "", "input network")
 	flag.StringVar(&outNet, "out", "", "output network")
 	flag.IntVar(&batchSize, "batch", 8, "evaluation batch size")
 	flag.IntVar(&sampleCount, "total", 512, "total samples for BatchNorm replacement")
+	flag.IntVar(&heldOutCount, "heldout", -1,
+		"held-out images for the equivalence check (0 to skip it, -1 for up to "+
+			"32 depending on the number of samples)")
+	flag.IntVar(&compareBatch, "compare-batch", 32,
+		"batch size (in rotated views) for the equivalence check")
+	flag.Int64Var(&seed, "seed", 0, "random seed for choosing samples")
+	flag.Float64Var(&tolerance, "tolerance", 0.05,
+		"maximum mean output deviation of the post-trained network")
+	flag.Float64Var(&minAgreement, "min-agreement", 0.95,
+		"minimum fraction of held-out views with the same right angle")
 
 	flag.Parse()
 
@@ -48,17 +81,30 @@ func main() {
 	if err := serializer.LoadAny(inNet, &net); err != nil {
 		essentials.Die("Failed to read network:", err)
 	}
+	original, err := autorot.CloneEvaluator(net)
+	if err != nil {
+		essentials.Die("Failed to copy network:", err)
+	}
 
 	log.Println("Loading samples...")
 	samples, err := autorot.ReadSampleList(net.InputSize, imgDir)
 	if err != nil {
 		essentials.Die("Failed to read sample listing:", err)
 	}
-	rand.Seed(time.Now().UnixNano())
+	samples.InputMode = net.InputMode
+	rand.Seed(seed)
 	anysgd.Shuffle(samples)
+	if heldOutCount < 0 {
+		heldOutCount = defaultHeldOut(samples.Len())
+	} else if heldOutCount > 0 && heldOutCount >= samples.Len() {
+		essentials.Die("Not enough samples to hold out", heldOutCount, "images.")
+	}
+	heldOut := samples.Slice(0, heldOutCount).(*autorot.SampleList)
+	samples = samples.Slice(heldOutCount, samples.Len()).(*autorot.SampleList)
 	if sampleCount < samples.Len() {
 		samples = samples.Slice(0, sampleCount).(*autorot.SampleList)
 	}
+	samples.SetRotationMix()
 
 	log.Println("Replacing BatchNorm layers...")
 	var numReplaced int
@@ -76,8 +122,57 @@ func main() {
 		essentials.Die("Post-training error:", err)
 	}
 
+	if heldOut.Len() > 0 {
+		log.Println("Comparing outputs on", heldOut.Len(), "held-out images...")
+		dev, err := compare(original.(*autorot.Net), net, heldOut.Paths, compareBatch)
+		if err != nil {
+			essentials.Die("Comparison failed:", err)
+		}
+		log.Printf("Max deviation: %f", dev.MaxDeviation)
+		log.Printf("Mean deviation: %f", dev.MeanDeviation)
+		log.Printf("Right angle agreement: %.2f%%", dev.Agreement*100)
+		if dev.Agreement < minAgreement {
+			essentials.Die(fmt.Sprintf("Agreement is below %.2f%%; not saving.",
+				minAgreement*100))
+		}
+		if dev.MeanDeviation > tolerance {
+			essentials.Die(fmt.Sprintf("Mean deviation exceeds tolerance (%f); not saving.",
+				tolerance))
+		}
+		if dev.MaxDeviation > tolerance {
+			log.Printf("Warning: max deviation exceeds tolerance (%f).", tolerance)
+		}
+	}
+
 	log.Println("Saving network...")
 	if err = serializer.SaveAny(outNet, net); err != nil {
 		essentials.Die("Failed to save:", err)
 	}
 }
+
+// defaultHeldOut chooses a number of held-out images which
+// leaves most of a small sample set for post-training.
+func defaultHeldOut(numSamples int) int {
+	count := numSamples / 4
+	if count > 32 {
+		count = 32
+	}
+	return count
+}
+
+// compare evaluates the networks on every right angle
+// rotation of the held-out images.
+func compare(original, postTrained *autorot.Net, paths []string,
+	batchSize int) (*autorot.OutputDeviation, error) {
+	var imgs []image.Image
+	for _, path := range paths {
+		img, _, err := autorot.ReadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		for turns := 0; turns < 4; turns++ {
+			imgs = append(imgs, autorot.RotateRightAngle(img, turns))
+		}
+	}
+	return autorot.CompareOutputs(original, postTrained, imgs, batchSize)
+}
@@ -0,0 +1,369 @@
+package autorot
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/serializer"
+)
+
+func init() {
+	var c QuantizedConv
+	serializer.RegisterTypedDeserializer(c.SerializerType(), DeserializeQuantizedConv)
+	var f QuantizedFC
+	serializer.RegisterTypedDeserializer(f.SerializerType(), DeserializeQuantizedFC)
+}
+
+// QuantizeNet creates a copy of a network in which every
+// convolutional and fully-connected layer is replaced with
+// an int8 equivalent (see QuantizedConv and QuantizedFC).
+// Layers inside residual blocks are replaced as well.
+//
+// The other layers are shared with the original network
+// and still run in float32.
+// Since BatchNorm layers cannot be folded into quantized
+// weights, networks should be processed with post_train
+// before being quantized.
+//
+// The resulting network can be evaluated and serialized
+// like any other, but it cannot be trained.
+// It returns the number of layers which were replaced.
+func QuantizeNet(net anynet.Net) (anynet.Net, int) {
+	var res anynet.Net
+	var count int
+	for _, layer := range net {
+		quantized, n := quantizeLayer(layer)
+		res = append(res, quantized)
+		count += n
+	}
+	return res, count
+}
+
+func quantizeLayer(layer anynet.Layer) (anynet.Layer, int) {
+	switch layer := layer.(type) {
+	case *anyconv.Conv:
+		return NewQuantizedConv(layer), 1
+	case *anynet.FC:
+		return NewQuantizedFC(layer), 1
+	case anynet.Net:
+		return QuantizeNet(layer)
+	case *anyconv.Residual:
+		res := &anyconv.Residual{}
+		var count, n int
+		res.Layer, count = quantizeLayer(layer.Layer)
+		if layer.Projection != nil {
+			res.Projection, n = quantizeLayer(layer.Projection)
+			count += n
+		}
+		return res, count
+	default:
+		return layer, 0
+	}
+}
+
+// A QuantizedConv is an int8 version of an anyconv.Conv.
+//
+// Each filter has its own scale, so that the quantized
+// weights of filter i are Filters[i]/Scales[i].
+// Inputs are quantized on the fly with one scale per
+// image, and dot products are accumulated in int32.
+type QuantizedConv struct {
+	FilterCount  int
+	FilterWidth  int
+	FilterHeight int
+	StrideX      int
+	StrideY      int
+	InputWidth   int
+	InputHeight  int
+	InputDepth   int
+
+	Filters []int8
+	Scales  []float32
+	Biases  []float32
+}
+
+// NewQuantizedConv quantizes the filters of a Conv.
+func NewQuantizedConv(c *anyconv.Conv) *QuantizedConv {
+	res := &QuantizedConv{
+		FilterCount:  c.FilterCount,
+		FilterWidth:  c.FilterWidth,
+		FilterHeight: c.FilterHeight,
+		StrideX:      c.StrideX,
+		StrideY:      c.StrideY,
+		InputWidth:   c.InputWidth,
+		InputHeight:  c.InputHeight,
+		InputDepth:   c.InputDepth,
+		Biases:       append([]float32{}, c.Biases.Vector.Data().([]float32)...),
+	}
+	res.Filters, res.Scales = quantizeRows(c.Filters.Vector.Data().([]float32),
+		c.FilterCount)
+	return res
+}
+
+// DeserializeQuantizedConv deserializes a QuantizedConv.
+func DeserializeQuantizedConv(d []byte) (*QuantizedConv, error) {
+	var res QuantizedConv
+	var filters []byte
+	err := serializer.DeserializeAny(d, &res.FilterCount, &res.FilterWidth,
+		&res.FilterHeight, &res.StrideX, &res.StrideY, &res.InputWidth, &res.InputHeight,
+		&res.InputDepth, &filters, &res.Scales, &res.Biases)
+	if err != nil {
+		return nil, errors.New("deserialize quantized conv: " + err.Error())
+	}
+	res.Filters = bytesToInt8(filters)
+	filterSize := res.FilterWidth * res.FilterHeight * res.InputDepth
+	if len(res.Filters) != res.FilterCount*filterSize || len(res.Scales) != res.FilterCount ||
+		len(res.Biases) != res.FilterCount {
+		return nil, errors.New("deserialize quantized conv: inconsistent sizes")
+	}
+	return &res, nil
+}
+
+// OutputWidth returns the width of the output tensor.
+func (q *QuantizedConv) OutputWidth() int {
+	return 1 + (q.InputWidth-q.FilterWidth)/q.StrideX
+}
+
+// OutputHeight returns the height of the output tensor.
+func (q *QuantizedConv) OutputHeight() int {
+	return 1 + (q.InputHeight-q.FilterHeight)/q.StrideY
+}
+
+// Apply applies the layer to a batch of inputs.
+//
+// Like the convolutions in a Plan, the input patches are
+// copied into matrix rows (im2col), and blocks of rows are
+// processed in parallel.
+//
+// The result is a constant, since quantized layers do not
+// support back-propagation.
+func (q *QuantizedConv) Apply(in anydiff.Res, n int) anydiff.Res {
+	inData := in.Output().Data().([]float32)
+	inSize := q.InputWidth * q.InputHeight * q.InputDepth
+	if len(inData) != n*inSize {
+		panic("incorrect input size")
+	}
+	outWidth := q.OutputWidth()
+	numRows := outWidth * q.OutputHeight()
+	outSize := numRows * q.FilterCount
+	rowSize := q.FilterWidth * q.InputDepth
+	patchSize := q.FilterHeight * rowSize
+
+	quantized, inScales := quantizeBatch(inData, n)
+
+	out := make([]float32, n*outSize)
+	tasksPerItem := (numRows + gemmRowBlock - 1) / gemmRowBlock
+	patchBufs := make([][]int8, parallelWorkers(n*tasksPerItem))
+	parallelFor(n*tasksPerItem, func(worker, task int) {
+		item := task / tasksPerItem
+		start := (task % tasksPerItem) * gemmRowBlock
+		end := start + gemmRowBlock
+		if end > numRows {
+			end = numRows
+		}
+		if patchBufs[worker] == nil {
+			patchBufs[worker] = make([]int8, gemmRowBlock*patchSize)
+		}
+		patches := patchBufs[worker]
+		itemIn := quantized[item*inSize : (item+1)*inSize]
+		for row := start; row < end; row++ {
+			y := (row / outWidth) * q.StrideY
+			x := (row % outWidth) * q.StrideX
+			patch := patches[(row-start)*patchSize : (row-start+1)*patchSize]
+			for fy := 0; fy < q.FilterHeight; fy++ {
+				inIdx := ((y+fy)*q.InputWidth + x) * q.InputDepth
+				copy(patch[fy*rowSize:(fy+1)*rowSize], itemIn[inIdx:inIdx+rowSize])
+			}
+		}
+		itemOut := out[item*outSize : (item+1)*outSize]
+		for row := start; row < end; row++ {
+			patch := patches[(row-start)*patchSize : (row-start+1)*patchSize]
+			rowOut := itemOut[row*q.FilterCount : (row+1)*q.FilterCount]
+			for f := range rowOut {
+				acc := dotInt8(patch, q.Filters[f*patchSize:(f+1)*patchSize])
+				rowOut[f] = float32(acc)*inScales[item]*q.Scales[f] + q.Biases[f]
+			}
+		}
+	})
+	return anydiff.NewConst(in.Output().Creator().MakeVectorData(out))
+}
+
+// SerializerType returns the unique ID used to serialize
+// a QuantizedConv with the serializer package.
+func (q *QuantizedConv) SerializerType() string {
+	return "github.com/unixpickle/autorot.QuantizedConv"
+}
+
+// Serialize serializes the layer.
+func (q *QuantizedConv) Serialize() ([]byte, error) {
+	return serializer.SerializeAny(
+		serializer.Int(q.FilterCount),
+		serializer.Int(q.FilterWidth),
+		serializer.Int(q.FilterHeight),
+		serializer.Int(q.StrideX),
+		serializer.Int(q.StrideY),
+		serializer.Int(q.InputWidth),
+		serializer.Int(q.InputHeight),
+		serializer.Int(q.InputDepth),
+		serializer.Bytes(int8ToBytes(q.Filters)),
+		serializer.Float32Slice(q.Scales),
+		serializer.Float32Slice(q.Biases),
+	)
+}
+
+// A QuantizedFC is an int8 version of an anynet.FC.
+//
+// Like QuantizedConv, it uses one scale per output and
+// quantizes inputs on the fly.
+type QuantizedFC struct {
+	InCount  int
+	OutCount int
+
+	Weights []int8
+	Scales  []float32
+	Biases  []float32
+}
+
+// NewQuantizedFC quantizes the weights of an FC layer.
+func NewQuantizedFC(f *anynet.FC) *QuantizedFC {
+	res := &QuantizedFC{
+		InCount:  f.InCount,
+		OutCount: f.OutCount,
+		Biases:   append([]float32{}, f.Biases.Vector.Data().([]float32)...),
+	}
+	res.Weights, res.Scales = quantizeRows(f.Weights.Vector.Data().([]float32), f.OutCount)
+	return res
+}
+
+// DeserializeQuantizedFC deserializes a QuantizedFC.
+func DeserializeQuantizedFC(d []byte) (*QuantizedFC, error) {
+	var res QuantizedFC
+	var weights []byte
+	err := serializer.DeserializeAny(d, &res.InCount, &res.OutCount, &weights,
+		&res.Scales, &res.Biases)
+	if err != nil {
+		return nil, errors.New("deserialize quantized FC: " + err.Error())
+	}
+	res.Weights = bytesToInt8(weights)
+	if len(res.Weights) != res.InCount*res.OutCount || len(res.Scales) != res.OutCount ||
+		len(res.Biases) != res.OutCount {
+		return nil, errors.New("deserialize quantized FC: inconsistent sizes")
+	}
+	return &res, nil
+}
+
+// Apply applies the layer to a batch of inputs.
+//
+// The result is a constant, since quantized layers do not
+// support back-propagation.
+func (q *QuantizedFC) Apply(in anydiff.Res, n int) anydiff.Res {
+	inData := in.Output().Data().([]float32)
+	if len(inData) != n*q.InCount {
+		panic("incorrect input size")
+	}
+	quantized, inScales := quantizeBatch(inData, n)
+	out := make([]float32, n*q.OutCount)
+	parallelFor(n, func(worker, i int) {
+		itemIn := quantized[i*q.InCount : (i+1)*q.InCount]
+		for j := 0; j < q.OutCount; j++ {
+			acc := dotInt8(itemIn, q.Weights[j*q.InCount:(j+1)*q.InCount])
+			out[i*q.OutCount+j] = float32(acc)*inScales[i]*q.Scales[j] + q.Biases[j]
+		}
+	})
+	return anydiff.NewConst(in.Output().Creator().MakeVectorData(out))
+}
+
+// SerializerType returns the unique ID used to serialize
+// a QuantizedFC with the serializer package.
+func (q *QuantizedFC) SerializerType() string {
+	return "github.com/unixpickle/autorot.QuantizedFC"
+}
+
+// Serialize serializes the layer.
+func (q *QuantizedFC) Serialize() ([]byte, error) {
+	return serializer.SerializeAny(
+		serializer.Int(q.InCount),
+		serializer.Int(q.OutCount),
+		serializer.Bytes(int8ToBytes(q.Weights)),
+		serializer.Float32Slice(q.Scales),
+		serializer.Float32Slice(q.Biases),
+	)
+}
+
+// quantizeRows quantizes each row of a row-major matrix
+// with its own symmetric scale.
+func quantizeRows(data []float32, rows int) ([]int8, []float32) {
+	cols := len(data) / rows
+	res := make([]int8, len(data))
+	scales := make([]float32, rows)
+	for i := range scales {
+		scales[i] = quantizeVector(data[i*cols:(i+1)*cols], res[i*cols:(i+1)*cols])
+	}
+	return res, scales
+}
+
+// quantizeBatch quantizes each of n inputs with its own
+// scale.
+func quantizeBatch(data []float32, n int) ([]int8, []float32) {
+	if n == 0 {
+		return nil, nil
+	}
+	size := len(data) / n
+	res := make([]int8, len(data))
+	scales := make([]float32, n)
+	parallelFor(n, func(worker, i int) {
+		scales[i] = quantizeVector(data[i*size:(i+1)*size], res[i*size:(i+1)*size])
+	})
+	return res, scales
+}
+
+// quantizeVector maps a vector to [-127, 127] and returns
+// the scale which maps the result back.
+func quantizeVector(data []float32, out []int8) float32 {
+	var maxAbs float32
+	for _, x := range data {
+		if x > maxAbs {
+			maxAbs = x
+		} else if -x > maxAbs {
+			maxAbs = -x
+		}
+	}
+	if maxAbs == 0 {
+		for i := range out {
+			out[i] = 0
+		}
+		return 0
+	}
+	scale := maxAbs / 127
+	for i, x := range data {
+		out[i] = int8(math.Round(float64(x / scale)))
+	}
+	return scale
+}
+
+func dotInt8(v1, v2 []int8) int32 {
+	var res int32
+	for i, x := range v1 {
+		res += int32(x) * int32(v2[i])
+	}
+	return res
+}
+
+func int8ToBytes(v []int8) []byte {
+	res := make([]byte, len(v))
+	for i, x := range v {
+		res[i] = byte(x)
+	}
+	return res
+}
+
+func bytesToInt8(v []byte) []int8 {
+	res := make([]int8, len(v))
+	for i, x := range v {
+		res[i] = int8(x)
+	}
+	return res
+}
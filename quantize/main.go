@@ -0,0 +1,162 @@
+// Command quantize converts the weights of an autorot
+// network to int8, trading a little accuracy for a network
+// about a quarter of the size.
+// Whether the quantized network is also faster depends on
+// the machine, so the comparison reports both speeds.
+//
+// The network must be processed with post_train first,
+// so that its BatchNorm layers are folded away.
+//
+// The quantized network is compared to the float32
+// network on a calibration directory of upright images,
+// each of which is rotated by all four right angles.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+	"github.com/unixpickle/serializer"
+)
+
+func main() {
+	var inPath string
+	var outPath string
+	var calibDir string
+	var batchSize int
+
+	flag.StringVar(&inPath, "in", "", "input network")
+	flag.StringVar(&outPath, "out", "", "output network")
+	flag.StringVar(&calibDir, "calib", "", "directory of upright images for the comparison")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size")
+	flag.Parse()
+
+	if inPath == "" || outPath == "" {
+		essentials.Die("Required flags: -in and -out. See -help for more.")
+	}
+
+	var net *autorot.Net
+	if err := serializer.LoadAny(inPath, &net); err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	if hasBatchNorm(net.Net) {
+		essentials.Die("Network has BatchNorm layers; run post_train first.")
+	}
+
+	quantized := *net
+	var count int
+	quantized.Net, count = autorot.QuantizeNet(net.Net)
+	if count == 0 {
+		essentials.Die("Network has no layers to quantize.")
+	}
+	log.Printf("Quantized %d layers.", count)
+
+	if calibDir != "" {
+		listing, err := autorot.ReadSampleList(net.InputSize, calibDir)
+		if err != nil {
+			essentials.Die("Load data failed:", err)
+		}
+		log.Println("Comparing on", listing.Len(), "images...")
+		compare(net, &quantized, listing.Paths, batchSize)
+	}
+
+	if err := serializer.SaveAny(outPath, &quantized); err != nil {
+		essentials.Die("Save failed:", err)
+	}
+}
+
+func hasBatchNorm(layer anynet.Layer) bool {
+	switch layer := layer.(type) {
+	case *anyconv.BatchNorm:
+		return true
+	case anynet.Net:
+		for _, sub := range layer {
+			if hasBatchNorm(sub) {
+				return true
+			}
+		}
+	case *anyconv.Residual:
+		return hasBatchNorm(layer.Layer) ||
+			(layer.Projection != nil && hasBatchNorm(layer.Projection))
+	}
+	return false
+}
+
+// compare evaluates both networks on every right angle
+// rotation of the images and prints their accuracies,
+// speeds, and how closely their outputs agree.
+func compare(floatNet, quantized *autorot.Net, paths []string, batchSize int) {
+	var floatCorrect, quantCorrect, agree, total int
+	var floatTime, quantTime time.Duration
+	var probDiff float64
+	for i := 0; i < len(paths); i += batchSize {
+		end := i + batchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		var views []image.Image
+		var truth []int
+		for _, path := range paths[i:end] {
+			img, _, err := autorot.ReadImage(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			for turns := 0; turns < 4; turns++ {
+				views = append(views, autorot.RotateRightAngle(img, turns))
+				truth = append(truth, turns)
+			}
+		}
+		if len(views) == 0 {
+			continue
+		}
+
+		start := time.Now()
+		floatAngles, _, floatProbs := floatNet.EvaluateProbs(views)
+		floatTime += time.Since(start)
+		start = time.Now()
+		quantAngles, _, quantProbs := quantized.EvaluateProbs(views)
+		quantTime += time.Since(start)
+
+		for j, turns := range truth {
+			floatPred := autorot.NearestRightAngle(floatAngles[j])
+			quantPred := autorot.NearestRightAngle(quantAngles[j])
+			if floatPred == turns {
+				floatCorrect++
+			}
+			if quantPred == turns {
+				quantCorrect++
+			}
+			if floatPred == quantPred {
+				agree++
+			}
+			for k, p := range floatProbs[j] {
+				probDiff = math.Max(probDiff, math.Abs(p-quantProbs[j][k]))
+			}
+		}
+		total += len(truth)
+	}
+	if total == 0 {
+		essentials.Die("No images could be evaluated.")
+	}
+
+	fmt.Printf("%-8s %10s %14s\n", "model", "accuracy", "ms/image")
+	fmt.Printf("%-8s %9.2f%% %14.3f\n", "float32", 100*float64(floatCorrect)/float64(total),
+		floatTime.Seconds()*1000/float64(total))
+	fmt.Printf("%-8s %9.2f%% %14.3f\n", "int8", 100*float64(quantCorrect)/float64(total),
+		quantTime.Seconds()*1000/float64(total))
+	fmt.Printf("\nPredictions agree on %.2f%% of %d images.\n",
+		100*float64(agree)/float64(total), total)
+	fmt.Printf("Largest right angle probability difference: %.4f\n", probDiff)
+}
@@ -0,0 +1,112 @@
+package autorot
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/anydiff"
+	"github.com/unixpickle/anynet"
+	"github.com/unixpickle/anynet/anyconv"
+	"github.com/unixpickle/anyvec"
+	"github.com/unixpickle/anyvec/anyvec32"
+	"github.com/unixpickle/serializer"
+)
+
+func TestQuantizedConv(t *testing.T) {
+	conv := &anyconv.Conv{
+		FilterCount:  3,
+		FilterWidth:  2,
+		FilterHeight: 3,
+		StrideX:      2,
+		StrideY:      1,
+		InputWidth:   7,
+		InputHeight:  5,
+		InputDepth:   2,
+		Filters:      anydiff.NewVar(randomVector(3 * 2 * 3 * 2)),
+		Biases:       anydiff.NewVar(randomVector(3)),
+	}
+	quantized := NewQuantizedConv(conv)
+	if quantized.OutputWidth() != conv.OutputWidth() ||
+		quantized.OutputHeight() != conv.OutputHeight() {
+		t.Fatal("output size mismatch")
+	}
+	in := anydiff.NewConst(randomVector(2 * 7 * 5 * 2))
+	testQuantizedOutputs(t, conv.Apply(in, 2), quantized.Apply(in, 2))
+	testQuantizedSerialize(t, quantized, in, 2)
+}
+
+func TestQuantizedFC(t *testing.T) {
+	fc := anynet.NewFC(anyvec32.CurrentCreator(), 10, 4)
+	quantized := NewQuantizedFC(fc)
+	in := anydiff.NewConst(randomVector(3 * 10))
+	testQuantizedOutputs(t, fc.Apply(in, 3), quantized.Apply(in, 3))
+	testQuantizedSerialize(t, quantized, in, 3)
+}
+
+func TestQuantizeNet(t *testing.T) {
+	net := testNet(6, RightAngles)
+	quantized, count := QuantizeNet(net.Net)
+	if count != 2 {
+		t.Errorf("expected 2 quantized layers but got %d", count)
+	}
+	if _, ok := net.Net[0].(*anynet.FC); !ok {
+		t.Error("original network was modified")
+	}
+	qNet := *net
+	qNet.Net = quantized
+	img := randomImage(6, 6)
+	expected := net.RightAngleProbs([]image.Image{img})[0]
+	actual := qNet.RightAngleProbs([]image.Image{img})[0]
+	for i, x := range expected {
+		if math.Abs(x-actual[i]) > 0.05 {
+			t.Errorf("prob %d: expected %f but got %f", i, x, actual[i])
+		}
+	}
+}
+
+func testQuantizedOutputs(t *testing.T, expected, actual anydiff.Res) {
+	expectedData := expected.Output().Data().([]float32)
+	actualData := actual.Output().Data().([]float32)
+	if len(expectedData) != len(actualData) {
+		t.Fatalf("expected %d outputs but got %d", len(expectedData), len(actualData))
+	}
+	// Allow errors relative to the largest output, since
+	// quantization error scales with the inputs and weights.
+	var maxAbs float64
+	for _, x := range expectedData {
+		maxAbs = math.Max(maxAbs, math.Abs(float64(x)))
+	}
+	for i, x := range expectedData {
+		if math.Abs(float64(x-actualData[i])) > 0.03*maxAbs {
+			t.Errorf("output %d: expected %f but got %f", i, x, actualData[i])
+		}
+	}
+}
+
+func testQuantizedSerialize(t *testing.T, layer anynet.Layer, in anydiff.Res, n int) {
+	data, err := serializer.SerializeWithType(layer.(serializer.Serializer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := serializer.DeserializeWithType(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := layer.Apply(in, n).Output().Data().([]float32)
+	actual := decoded.(anynet.Layer).Apply(in, n).Output().Data().([]float32)
+	for i, x := range expected {
+		if x != actual[i] {
+			t.Errorf("decoded output %d: expected %f but got %f", i, x, actual[i])
+		}
+	}
+}
+
+func randomVector(size int) anyvec.Vector {
+	data := make([]float32, size)
+	for i := range data {
+		data[i] = float32(rand.NormFloat64())
+	}
+	return anyvec32.MakeVectorData(data)
+}
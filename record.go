@@ -0,0 +1,259 @@
+package autorot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Record formats supported by RecordWriter and
+// ReadRecords.
+const (
+	CSVRecords   = "csv"
+	TSVRecords   = "tsv"
+	JSONLRecords = "jsonl"
+)
+
+// recordColumns is the header for CSV and TSV records.
+var recordColumns = []string{
+	"path", "width", "height", "format", "angle", "degrees", "right_angle",
+	"confidence", "prob_0", "prob_90", "prob_180", "prob_270", "model",
+	"size", "mod_time", "hash", "error",
+}
+
+// A Record is the result of classifying one image file.
+//
+// If Error is set, the image could not be classified and
+// only the path and file fields are meaningful.
+type Record struct {
+	Path string `json:"path"`
+
+	// Width, Height, and Format describe the decoded image.
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Format string `json:"format,omitempty"`
+
+	// Angle is the predicted clockwise rotation in radians,
+	// and Degrees is the same angle in degrees.
+	Angle   float64 `json:"angle"`
+	Degrees float64 `json:"degrees"`
+
+	// RightAngle is the index of the nearest right angle,
+	// from 0 (0 degrees) to 3 (270 degrees).
+	RightAngle int `json:"right_angle"`
+
+	Confidence float64 `json:"confidence"`
+
+	// Probs contains the probability of each right angle,
+	// or is nil if the evaluator could not produce them.
+	Probs []float64 `json:"probs,omitempty"`
+
+	// Model identifies the evaluator, typically by the hash
+	// of its file.
+	Model string `json:"model,omitempty"`
+
+	// Size, ModTime (in Unix nanoseconds), and Hash
+	// identify the version of the file that was classified.
+	// Hash may be empty.
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	Hash    string `json:"hash,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// SetPrediction fills in the prediction fields from an
+// angle and confidence.
+func (r *Record) SetPrediction(angle, confidence float64) {
+	r.Angle = angle
+	r.Degrees = angle * 180 / math.Pi
+	r.RightAngle = NearestRightAngle(angle)
+	r.Confidence = confidence
+}
+
+// A RecordWriter writes records in one of the supported
+// formats, flushing after each record so that interrupted
+// output ends with at most one partial line.
+type RecordWriter struct {
+	format string
+	w      io.Writer
+	csv    *csv.Writer
+}
+
+// NewRecordWriter creates a RecordWriter for a format.
+func NewRecordWriter(w io.Writer, format string) (*RecordWriter, error) {
+	res := &RecordWriter{format: format, w: w}
+	switch format {
+	case CSVRecords, TSVRecords:
+		res.csv = csv.NewWriter(w)
+		if format == TSVRecords {
+			res.csv.Comma = '\t'
+		}
+	case JSONLRecords:
+	default:
+		return nil, errors.New("record writer: unknown format " + format)
+	}
+	return res, nil
+}
+
+// WriteHeader writes the column names for CSV and TSV.
+// It does nothing for JSON Lines, whose field names match
+// the JSON tags of Record.
+func (r *RecordWriter) WriteHeader() error {
+	if r.csv == nil {
+		return nil
+	}
+	r.csv.Write(recordColumns)
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+// Write writes and flushes a record.
+func (r *RecordWriter) Write(rec *Record) error {
+	if r.csv == nil {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = r.w.Write(append(data, '\n'))
+		return err
+	}
+	r.csv.Write(rec.columns())
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+func (r *Record) columns() []string {
+	res := []string{r.Path, "", "", "", "", "", "", "", "", "", "", "", r.Model,
+		strconv.FormatInt(r.Size, 10), strconv.FormatInt(r.ModTime, 10), r.Hash, r.Error}
+	if r.Error != "" {
+		return res
+	}
+	res[1] = strconv.Itoa(r.Width)
+	res[2] = strconv.Itoa(r.Height)
+	res[3] = r.Format
+	res[4] = formatFloat(r.Angle)
+	res[5] = formatFloat(r.Degrees)
+	res[6] = strconv.Itoa(r.RightAngle)
+	res[7] = formatFloat(r.Confidence)
+	for i, p := range r.Probs {
+		res[8+i] = formatFloat(p)
+	}
+	return res
+}
+
+// ReadRecords reads records written by a RecordWriter.
+//
+// For CSV and TSV, a header row is required.
+func ReadRecords(r io.Reader, format string) ([]*Record, error) {
+	switch format {
+	case JSONLRecords:
+		var res []*Record
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<24)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var rec Record
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, err
+			}
+			res = append(res, &rec)
+		}
+		return res, scanner.Err()
+	case CSVRecords, TSVRecords:
+		reader := csv.NewReader(r)
+		if format == TSVRecords {
+			reader.Comma = '\t'
+		}
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		columns := map[string]int{}
+		for i, name := range rows[0] {
+			columns[name] = i
+		}
+		if _, ok := columns["path"]; !ok {
+			return nil, errors.New("read records: missing header")
+		}
+		var res []*Record
+		for _, row := range rows[1:] {
+			rec, err := parseRecordRow(columns, row)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, rec)
+		}
+		return res, nil
+	default:
+		return nil, errors.New("read records: unknown format " + format)
+	}
+}
+
+func parseRecordRow(columns map[string]int, row []string) (*Record, error) {
+	field := func(name string) string {
+		if idx, ok := columns[name]; ok && idx < len(row) {
+			return row[idx]
+		}
+		return ""
+	}
+	var parseErr error
+	parseInt := func(name string) int64 {
+		s := field(name)
+		if s == "" {
+			return 0
+		}
+		x, err := strconv.ParseInt(s, 10, 64)
+		if err != nil && parseErr == nil {
+			parseErr = errors.New("read records: bad " + name + ": " + s)
+		}
+		return x
+	}
+	parseFloat := func(name string) float64 {
+		s := field(name)
+		if s == "" {
+			return 0
+		}
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil && parseErr == nil {
+			parseErr = errors.New("read records: bad " + name + ": " + s)
+		}
+		return x
+	}
+	rec := &Record{
+		Path:       field("path"),
+		Width:      int(parseInt("width")),
+		Height:     int(parseInt("height")),
+		Format:     field("format"),
+		Angle:      parseFloat("angle"),
+		Degrees:    parseFloat("degrees"),
+		RightAngle: int(parseInt("right_angle")),
+		Confidence: parseFloat("confidence"),
+		Model:      field("model"),
+		Size:       parseInt("size"),
+		ModTime:    parseInt("mod_time"),
+		Hash:       field("hash"),
+		Error:      field("error"),
+	}
+	if field("prob_0") != "" {
+		for _, name := range recordColumns[8:12] {
+			rec.Probs = append(rec.Probs, parseFloat(name))
+		}
+	}
+	return rec, parseErr
+}
+
+func formatFloat(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
@@ -0,0 +1,57 @@
+package autorot
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	success := &Record{
+		Path:    "a/b,c.jpg",
+		Width:   640,
+		Height:  480,
+		Format:  "jpeg",
+		Probs:   []float64{0.1, 0.7, 0.1, 0.1},
+		Model:   "abc123",
+		Size:    1234,
+		ModTime: 1500000000000000000,
+		Hash:    "deadbeef",
+	}
+	success.SetPrediction(math.Pi/2, 0.7)
+	failure := &Record{
+		Path:  "broken.png",
+		Model: "abc123",
+		Size:  17,
+		Error: "decode broken.png: unexpected EOF",
+	}
+	for _, format := range []string{CSVRecords, TSVRecords, JSONLRecords} {
+		var buf bytes.Buffer
+		w, err := NewRecordWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteHeader(); err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range []*Record{success, failure} {
+			if err := w.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		records, err := ReadRecords(&buf, format)
+		if err != nil {
+			t.Fatalf("format %s: %s", format, err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("format %s: expected 2 records but got %d", format, len(records))
+		}
+		if !reflect.DeepEqual(records[0], success) {
+			t.Errorf("format %s: expected %v but got %v", format, success, records[0])
+		}
+		if !reflect.DeepEqual(records[1], failure) {
+			t.Errorf("format %s: expected %v but got %v", format, failure, records[1])
+		}
+	}
+}
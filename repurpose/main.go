@@ -1,11 +1,17 @@
 // Command repurpose converts an ImageNet classifier to an
 // autorot network.
+//
+// It can also replace the head of an existing autorot
+// network, e.g. to switch output types while keeping the
+// trained backbone.
+// The calibration of the source network is not kept, since
+// it only applies to the old head.
 package main
 
 import (
 	"flag"
+	"fmt"
 
-	"github.com/unixpickle/anydiff"
 	"github.com/unixpickle/anynet"
 	"github.com/unixpickle/anyvec/anyvec32"
 	"github.com/unixpickle/autorot"
@@ -20,48 +26,98 @@ func main() {
 	var removeLayers int
 	var rightAngles bool
 	var confidence bool
+	var letterbox bool
+	var notes string
+	var head autorot.HeadConfig
+	var activation string
 
-	flag.StringVar(&inFile, "in", "", "imagenet classifier path")
+	flag.StringVar(&inFile, "in", "", "imagenet classifier or autorot network path")
 	flag.StringVar(&outFile, "out", "", "output network path")
-	flag.IntVar(&removeLayers, "remove", 2, "number of layers to remove")
+	flag.IntVar(&removeLayers, "remove", -1,
+		"number of layers to remove (default: 2 for classifiers, the head for autorot nets)")
 	flag.BoolVar(&rightAngles, "rightangles", false, "use right angles")
 	flag.BoolVar(&confidence, "confidence", false, "use confidence and angle outputs")
+	flag.BoolVar(&letterbox, "letterbox", false, "fit whole images into the input with padding")
+	flag.StringVar(&notes, "notes", "", "notes to store in the network metadata")
+	flag.BoolVar(&head.GlobalPool, "pool", false, "globally average pool the backbone features")
+	flag.IntVar(&head.HiddenLayers, "hidden", 0, "number of hidden layers in the head")
+	flag.IntVar(&head.HiddenSize, "hidden-size", 256, "number of units per hidden layer")
+	flag.StringVar(&activation, "activation", "relu",
+		"hidden layer activation (relu, tanh, or sigmoid)")
+	flag.Float64Var(&head.Dropout, "dropout", 0, "dropout probability for hidden layers")
 
 	flag.Parse()
 
 	if inFile == "" || outFile == "" {
 		essentials.Die("Required flags: -in and -out. See -help for more.")
 	}
+	var err error
+	head.Activation, err = autorot.ParseActivation(activation)
+	if err != nil {
+		essentials.Die(err)
+	}
+	if head.Dropout < 0 || head.Dropout >= 1 {
+		essentials.Die("Dropout must be in [0, 1).")
+	}
 
-	var inNet *imagenet.Classifier
-	if err := serializer.LoadAny(inFile, &inNet); err != nil {
+	var source serializer.Serializer
+	if err := serializer.LoadAny(inFile, &source); err != nil {
 		essentials.Die("Load input failed:", err)
 	}
-	if inNet.InWidth != inNet.InHeight {
-		essentials.Die("Input dimensions do not form a square.")
-	}
 
-	newNet := inNet.Net[:len(inNet.Net)-removeLayers]
-	zeroIn := anydiff.NewConst(anyvec32.MakeVector(inNet.InWidth * inNet.InHeight * 3))
-	outCount := newNet.Apply(zeroIn, 1).Output().Len()
-	if rightAngles {
-		newNet = append(newNet, anynet.NewFC(anyvec32.CurrentCreator(), outCount, 4),
-			anynet.LogSoftmax)
-	} else if confidence {
-		newNet = append(newNet, anynet.NewFC(anyvec32.CurrentCreator(), outCount, 2))
-	} else {
-		newNet = append(newNet, anynet.NewFC(anyvec32.CurrentCreator(), outCount, 1))
+	var out *autorot.Net
+	var backbone anynet.Net
+	switch source := source.(type) {
+	case *imagenet.Classifier:
+		if source.InWidth != source.InHeight {
+			essentials.Die("Input dimensions do not form a square.")
+		}
+		if removeLayers < 0 {
+			removeLayers = 2
+		}
+		backbone = source.Net
+		out = &autorot.Net{
+			InputSize: source.InWidth,
+			Metadata:  autorot.NewMetadata(),
+		}
+	case *autorot.Net:
+		if removeLayers < 0 {
+			removeLayers = source.HeadLength()
+		}
+		backbone = source.Net
+		out = &autorot.Net{
+			InputSize: source.InputSize,
+			InputMode: source.InputMode,
+			Metadata:  source.Metadata,
+		}
+	default:
+		essentials.Die(fmt.Sprintf("Unsupported input type: %T", source))
 	}
-	out := &autorot.Net{
-		InputSize:  inNet.InWidth,
-		OutputType: autorot.RawAngle,
-		Net:        newNet,
+	if removeLayers > len(backbone) {
+		essentials.Die("Cannot remove more layers than the network has.")
 	}
+	backbone = append(anynet.Net{}, backbone[:len(backbone)-removeLayers]...)
+
+	out.OutputType = autorot.RawAngle
 	if rightAngles {
 		out.OutputType = autorot.RightAngles
 	} else if confidence {
 		out.OutputType = autorot.ConfidenceAngle
 	}
+
+	headNet, err := head.Build(anyvec32.CurrentCreator(), backbone, out.InputSize,
+		out.OutputType)
+	if err != nil {
+		essentials.Die(err)
+	}
+	out.Net = append(backbone, headNet...)
+	out.Metadata.HeadLayers = len(headNet)
+	if notes != "" {
+		out.Metadata.Notes = notes
+	}
+	if letterbox {
+		out.InputMode = autorot.Letterboxed
+	}
 	if err := serializer.SaveAny(outFile, out); err != nil {
 		essentials.Die("Save failed:", err)
 	}
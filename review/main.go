@@ -0,0 +1,156 @@
+// Command review serves a local web page for confirming or
+// overriding predictions, starting with the least
+// confident ones.
+//
+// Predictions are read from the output of classify, or
+// computed by running a network on a directory.
+// Decisions are appended to a label manifest, which can be
+// used for training (see autorot.ReadManifest).
+package main
+
+import (
+	"flag"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+)
+
+// An Item is an image awaiting review.
+type Item struct {
+	Path       string  `json:"path"`
+	Angle      float64 `json:"angle"`
+	Confidence float64 `json:"confidence"`
+
+	// Label is the existing decision for the image, if
+	// any.
+	Label *autorot.Label `json:"label"`
+}
+
+func main() {
+	var recordsPath string
+	var format string
+	var dirPath string
+	var netPath string
+	var batchSize int
+	var maxConfidence float64
+	var server Server
+	var addr string
+	flag.StringVar(&recordsPath, "records", "", "classify output to review")
+	flag.StringVar(&format, "format", autorot.CSVRecords, "format of -records (csv, jsonl, or tsv)")
+	flag.StringVar(&dirPath, "dir", "", "image directory to classify (instead of -records)")
+	flag.StringVar(&netPath, "net", "", "network or ensemble for -dir")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size for -dir")
+	flag.Float64Var(&maxConfidence, "max-confidence", 1, "only review predictions below this")
+	flag.StringVar(&server.ManifestPath, "manifest", "", "label manifest to append to")
+	flag.IntVar(&server.ThumbSize, "thumb", 256, "thumbnail size")
+	flag.StringVar(&addr, "addr", "localhost:8090", "address to listen on")
+	flag.Parse()
+
+	if server.ManifestPath == "" || (recordsPath == "") == (dirPath == "") {
+		essentials.Die("Required flags: -manifest and one of -records or -dir. " +
+			"See -help for more.")
+	}
+	if dirPath != "" && netPath == "" {
+		essentials.Die("The -dir flag requires -net.")
+	}
+
+	var items []*Item
+	var err error
+	if recordsPath != "" {
+		items, err = readRecords(recordsPath, format)
+	} else {
+		items, err = classifyDir(dirPath, netPath, batchSize)
+	}
+	if err != nil {
+		essentials.Die(err)
+	}
+
+	filtered := []*Item{}
+	for _, item := range items {
+		if item.Confidence < maxConfidence {
+			filtered = append(filtered, item)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Confidence < filtered[j].Confidence
+	})
+	server.Items = filtered
+
+	if _, err := os.Stat(server.ManifestPath); err == nil {
+		labels, err := autorot.ReadLabels(server.ManifestPath)
+		if err != nil {
+			essentials.Die("Read manifest failed:", err)
+		}
+		server.AddLabels(labels)
+	}
+
+	log.Printf("Reviewing %d images at http://%s", len(server.Items), addr)
+	if err := http.ListenAndServe(addr, &server); err != nil {
+		essentials.Die(err)
+	}
+}
+
+func readRecords(path, format string) ([]*Item, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := autorot.ReadRecords(f, format)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Item
+	for _, rec := range records {
+		if rec.Error == "" {
+			res = append(res, &Item{Path: rec.Path, Angle: rec.Angle,
+				Confidence: rec.Confidence})
+		}
+	}
+	return res, nil
+}
+
+func classifyDir(dir, netPath string, batchSize int) ([]*Item, error) {
+	model, err := autorot.LoadEvaluator(netPath)
+	if err != nil {
+		return nil, err
+	}
+	listing, err := autorot.ReadSampleList(0, dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []*Item
+	for i := 0; i < len(listing.Paths); i += batchSize {
+		end := i + batchSize
+		if end > len(listing.Paths) {
+			end = len(listing.Paths)
+		}
+		var paths []string
+		var imgs []image.Image
+		for _, path := range listing.Paths[i:end] {
+			img, _, err := autorot.ReadImage(path)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			paths = append(paths, path)
+			imgs = append(imgs, img)
+		}
+		if len(imgs) == 0 {
+			continue
+		}
+		angles, confidences := model.EvaluateBatch(imgs)
+		for j, path := range paths {
+			res = append(res, &Item{Path: path, Angle: angles[j], Confidence: confidences[j]})
+		}
+		log.Printf("Classified %d/%d images", end, len(listing.Paths))
+	}
+	return res, nil
+}
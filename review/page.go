@@ -0,0 +1,128 @@
+package main
+
+import "html/template"
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>autorot review</title>
+<style>
+body { font-family: sans-serif; margin: 1em; background: #222; color: #eee; }
+#help { margin-bottom: 1em; color: #aaa; }
+.item { display: inline-block; margin: 6px; padding: 6px; border: 3px solid #444;
+	vertical-align: top; text-align: center; }
+.item.selected { border-color: #4af; }
+.item.done { opacity: 0.45; }
+.frame { width: {{.Size}}px; height: {{.Size}}px; overflow: hidden; }
+.frame img { transition: transform 0.1s; }
+.info { font-size: 12px; margin: 4px 0; }
+button { margin: 0 2px; }
+</style>
+</head>
+<body>
+<div id="help">
+Images are shown with the predicted correction applied.
+Keys: <b>j</b>/<b>k</b> next/previous, <b>r</b>/<b>e</b> rotate right/left,
+<b>a</b> or <b>Enter</b> accept, <b>s</b> skip.
+</div>
+<div id="items"></div>
+<script>
+var items = {{.Items}};
+var selected = 0;
+
+function correctionTurns(angle) {
+	var k = Math.round(angle / (Math.PI / 2));
+	return (((4 - k) % 4) + 4) % 4;
+}
+
+function render(i) {
+	var item = items[i];
+	var el = document.getElementById('item-' + i);
+	el.className = 'item' + (i === selected ? ' selected' : '') + (item.label ? ' done' : '');
+	el.querySelector('img').style.transform = 'rotate(' + (item.turns * 90) + 'deg)';
+	var status = 'confidence ' + item.confidence.toFixed(3);
+	if (item.label) {
+		status += item.label.skip ? ' - skipped' : ' - labeled';
+	}
+	el.querySelector('.info').textContent = status;
+}
+
+function select(i) {
+	if (i < 0 || i >= items.length) {
+		return;
+	}
+	var old = selected;
+	selected = i;
+	render(old);
+	render(i);
+	document.getElementById('item-' + i).scrollIntoView({block: 'nearest'});
+}
+
+function rotate(i, delta) {
+	items[i].turns = (items[i].turns + delta + 4) % 4;
+	render(i);
+}
+
+function decide(i, skip) {
+	var xhr = new XMLHttpRequest();
+	xhr.open('POST', '/label');
+	xhr.setRequestHeader('Content-Type', 'application/json');
+	xhr.onload = function() {
+		if (xhr.status !== 200) {
+			alert('Saving failed: ' + xhr.responseText);
+			return;
+		}
+		items[i].label = JSON.parse(xhr.responseText);
+		render(i);
+	};
+	xhr.send(JSON.stringify({index: i, turns: items[i].turns, skip: skip}));
+	select(i + 1);
+}
+
+function build() {
+	var container = document.getElementById('items');
+	items.forEach(function(item, i) {
+		item.turns = correctionTurns(item.label ? item.label.angle : item.angle);
+		var el = document.createElement('div');
+		el.id = 'item-' + i;
+		el.innerHTML = '<div class="frame"><img loading="lazy"></div>' +
+			'<div class="info"></div>' +
+			'<button data-action="left">&#8634;</button>' +
+			'<button data-action="right">&#8635;</button>' +
+			'<button data-action="accept">Accept</button>' +
+			'<button data-action="skip">Skip</button>';
+		el.querySelector('img').src = '/thumb?index=' + i;
+		el.title = item.path;
+		el.addEventListener('click', function(e) {
+			select(i);
+			switch (e.target.getAttribute('data-action')) {
+			case 'left': rotate(i, -1); break;
+			case 'right': rotate(i, 1); break;
+			case 'accept': decide(i, false); break;
+			case 'skip': decide(i, true); break;
+			}
+		});
+		container.appendChild(el);
+		render(i);
+	});
+}
+
+document.addEventListener('keydown', function(e) {
+	switch (e.key) {
+	case 'j': case 'ArrowRight': select(selected + 1); break;
+	case 'k': case 'ArrowLeft': select(selected - 1); break;
+	case 'r': rotate(selected, 1); break;
+	case 'e': rotate(selected, -1); break;
+	case 'a': case 'Enter': decide(selected, false); break;
+	case 's': decide(selected, true); break;
+	default: return;
+	}
+	e.preventDefault();
+});
+
+build();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/autorot"
+)
+
+// A Server serves the review page and records decisions.
+type Server struct {
+	Items        []*Item
+	ManifestPath string
+	ThumbSize    int
+
+	lock sync.Mutex
+}
+
+// A Decision is posted by the review page.
+type Decision struct {
+	Index int `json:"index"`
+
+	// Turns is the number of clockwise quarter turns which
+	// make the stored image upright.
+	Turns int  `json:"turns"`
+	Skip  bool `json:"skip"`
+}
+
+// AddLabels attaches existing labels to the items.
+func (s *Server) AddLabels(labels []*autorot.Label) {
+	byPath := map[string]*autorot.Label{}
+	for _, l := range labels {
+		byPath[l.Path] = l
+	}
+	for _, item := range s.Items {
+		item.Label = byPath[item.Path]
+	}
+}
+
+// ServeHTTP routes requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		s.servePage(w, r)
+	case "/thumb":
+		s.serveThumb(w, r)
+	case "/label":
+		s.serveLabel(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) servePage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	items, err := json.Marshal(s.Items)
+	s.lock.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pageTemplate.Execute(w, map[string]interface{}{
+		"Items": template.JS(items),
+		"Size":  s.ThumbSize,
+	})
+}
+
+func (s *Server) serveThumb(w http.ResponseWriter, r *http.Request) {
+	item, err := s.item(r.URL.Query().Get("index"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	img, _, err := autorot.ReadImage(item.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	thumb := autorot.Letterbox(img, s.ThumbSize)
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	jpeg.Encode(w, thumb, &jpeg.Options{Quality: 85})
+}
+
+func (s *Server) serveLabel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var d Decision
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item, err := s.item(strconv.Itoa(d.Index))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	label := &autorot.Label{
+		Path: item.Path,
+		// Undoing the stored rotation takes Turns quarter
+		// turns, so the stored rotation is the complement.
+		Angle: float64((4-((d.Turns%4)+4)%4)%4) * math.Pi / 2,
+		Skip:  d.Skip,
+		Time:  time.Now(),
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := autorot.AppendLabel(s.ManifestPath, label); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	item.Label = label
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(label)
+}
+
+func (s *Server) item(indexStr string) (*Item, error) {
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 || index >= len(s.Items) {
+		return nil, errors.New("no such item")
+	}
+	return s.Items[index], nil
+}
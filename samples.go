@@ -1,6 +1,8 @@
 package autorot
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
@@ -8,6 +10,9 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/unixpickle/anynet/anyff"
 	"github.com/unixpickle/anynet/anysgd"
@@ -23,6 +28,23 @@ import (
 type SampleList struct {
 	Paths     []string
 	ImageSize int
+
+	// InputMode determines how images are fit into the
+	// square inputs.
+	// It should match the InputMode of the Net.
+	InputMode InputMode
+
+	// Orientations, if non-nil, contains the clockwise
+	// rotation (in radians) of each stored image relative
+	// to upright, e.g. from ReadManifest.
+	// If it is nil, every image is assumed to be upright.
+	Orientations []float64
+
+	// Rotations, if non-nil, contains the clockwise angle
+	// (in radians) by which to rotate each upright sample,
+	// replacing the usual random right angle.
+	// This makes the samples deterministic.
+	Rotations []float64
 }
 
 // ReadSampleList walks the directory and creates a sample
@@ -33,7 +55,7 @@ func ReadSampleList(imageSize int, dir string) (*SampleList, error) {
 		if err != nil {
 			return err
 		}
-		ext := filepath.Ext(path)
+		ext := strings.ToLower(filepath.Ext(path))
 		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
 			res.Paths = append(res.Paths, path)
 		}
@@ -53,23 +75,82 @@ func (s *SampleList) Len() int {
 // Swap swaps two sample indices.
 func (s *SampleList) Swap(i, j int) {
 	s.Paths[i], s.Paths[j] = s.Paths[j], s.Paths[i]
+	if s.Orientations != nil {
+		s.Orientations[i], s.Orientations[j] = s.Orientations[j], s.Orientations[i]
+	}
+	if s.Rotations != nil {
+		s.Rotations[i], s.Rotations[j] = s.Rotations[j], s.Rotations[i]
+	}
+}
+
+// Append adds the samples from another list.
+//
+// Rotations are only kept if both lists have them.
+func (s *SampleList) Append(other *SampleList) {
+	if s.Orientations != nil || other.Orientations != nil {
+		s.Orientations = append(s.orientations(), other.orientations()...)
+	}
+	if s.Rotations != nil && other.Rotations != nil {
+		s.Rotations = append(s.Rotations, other.Rotations...)
+	} else {
+		s.Rotations = nil
+	}
+	s.Paths = append(s.Paths, other.Paths...)
+}
+
+// orientations returns Orientations, or a slice of zeros if
+// Orientations is nil.
+func (s *SampleList) orientations() []float64 {
+	if s.Orientations != nil {
+		return s.Orientations
+	}
+	return make([]float64, len(s.Paths))
+}
+
+// Hash computes a hash of the samples which can be used
+// to identify the dataset.
+// It does not depend on the order of the samples.
+//
+// Along with its path, each file's size and modification
+// time are included, so that changing an image changes
+// the hash without reading every file.
+// Orientations other than upright are included as well,
+// since they change the meaning of a sample.
+func (s *SampleList) Hash() string {
+	var keys []string
+	for i, path := range s.Paths {
+		key := path
+		if info, err := os.Stat(path); err == nil {
+			key += "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" +
+				strconv.FormatInt(info.ModTime().UnixNano(), 10)
+		}
+		if s.Orientations != nil && s.Orientations[i] != 0 {
+			key += "\x00" + strconv.FormatFloat(s.Orientations[i], 'g', -1, 64)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // GetSample generates a rotated and scaled image tensor
 // for the given sample index.
 func (s *SampleList) GetSample(idx int) (*anyff.Sample, error) {
-	path := s.Paths[idx]
-	f, err := os.Open(path)
+	img, _, err := ReadImage(s.Paths[idx])
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	img, _, err := image.Decode(f)
-	if err != nil {
-		return nil, err
+	theta := s.rotation(idx)
+	var orientation float64
+	if s.Orientations != nil {
+		orientation = s.Orientations[idx]
 	}
-	theta := randomAngle()
-	rotated := Rotate(img, theta, s.ImageSize)
+	rotated := fitInput(img, theta-orientation, s.ImageSize, s.InputMode)
 	outVec := []float32{float32(theta)}
 	inVec := netInputTensor(rotated)
 	return &anyff.Sample{
@@ -80,10 +161,37 @@ func (s *SampleList) GetSample(idx int) (*anyff.Sample, error) {
 
 // Slice returns a subset of the list.
 func (s *SampleList) Slice(i, j int) anysgd.SampleList {
-	return &SampleList{
+	res := &SampleList{
 		Paths:     append([]string{}, s.Paths[i:j]...),
 		ImageSize: s.ImageSize,
+		InputMode: s.InputMode,
+	}
+	if s.Orientations != nil {
+		res.Orientations = append([]float64{}, s.Orientations[i:j]...)
+	}
+	if s.Rotations != nil {
+		res.Rotations = append([]float64{}, s.Rotations[i:j]...)
+	}
+	return res
+}
+
+// SetRotationMix fixes the rotation of every sample,
+// cycling through the four right angles so that each one
+// is used equally often.
+func (s *SampleList) SetRotationMix() {
+	s.Rotations = make([]float64, len(s.Paths))
+	for i := range s.Rotations {
+		s.Rotations[i] = float64(i%4) * math.Pi / 2
+	}
+}
+
+// rotation returns the clockwise angle by which to rotate
+// the upright version of a sample.
+func (s *SampleList) rotation(idx int) float64 {
+	if s.Rotations != nil {
+		return s.Rotations[idx]
 	}
+	return randomAngle()
 }
 
 func randomAngle() float64 {
@@ -0,0 +1,65 @@
+package autorot
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSampleListRotations(t *testing.T) {
+	s := &SampleList{Paths: []string{"a", "b", "c", "d", "e", "f"}}
+	s.SetRotationMix()
+	var counts [4]int
+	for i, rotation := range s.Rotations {
+		counts[NearestRightAngle(rotation)]++
+		if s.rotation(i) != rotation {
+			t.Errorf("sample %d: expected rotation %f", i, rotation)
+		}
+	}
+	if counts != [4]int{2, 2, 1, 1} {
+		t.Errorf("unbalanced rotations: %v", counts)
+	}
+
+	s.Swap(0, 1)
+	if s.Paths[0] != "b" || s.Rotations[0] != math.Pi/2 || s.Rotations[1] != 0 {
+		t.Error("swap did not move rotations")
+	}
+	sliced := s.Slice(1, 3).(*SampleList)
+	if len(sliced.Rotations) != 2 || sliced.Rotations[0] != 0 || sliced.Rotations[1] != math.Pi {
+		t.Errorf("unexpected sliced rotations: %v", sliced.Rotations)
+	}
+
+	sliced.Append(&SampleList{Paths: []string{"g"}})
+	if sliced.Rotations != nil {
+		t.Error("appending a random list should drop rotations")
+	}
+}
+
+func TestSampleListHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autorot-samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	for _, name := range []string{"a.png", "b.png"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("image "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	hash := (&SampleList{Paths: paths}).Hash()
+	if h := (&SampleList{Paths: []string{paths[1], paths[0]}}).Hash(); h != hash {
+		t.Error("order should not affect the hash")
+	}
+	if err := ioutil.WriteFile(paths[1], []byte("a longer image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if h := (&SampleList{Paths: paths}).Hash(); h == hash {
+		t.Error("changed file should affect the hash")
+	}
+}
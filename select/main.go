@@ -0,0 +1,185 @@
+// Command select picks the most uncertain images from a
+// pool of unlabeled images for the next review round.
+//
+// Uncertainty is measured either by a low confidence or,
+// for ensembles, by disagreement between the nets.
+// The selected images are written as classify records,
+// which can be passed to the review command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+)
+
+// A candidate is an image with an uncertainty score.
+type candidate struct {
+	Record *autorot.Record
+	Score  float64
+}
+
+func main() {
+	var dirPath string
+	var netPath string
+	var outPath string
+	var format string
+	var method string
+	var excludePath string
+	var count int
+	var batchSize int
+	flag.StringVar(&dirPath, "dir", "", "directory of unlabeled images")
+	flag.StringVar(&netPath, "net", "", "network or ensemble path")
+	flag.StringVar(&outPath, "out", "", "output records path")
+	flag.StringVar(&format, "format", autorot.CSVRecords, "output format (csv, jsonl, or tsv)")
+	flag.StringVar(&method, "method", "confidence",
+		"uncertainty measure (confidence or disagreement)")
+	flag.StringVar(&excludePath, "exclude", "", "label manifest of images to leave out")
+	flag.IntVar(&count, "n", 100, "number of images to select")
+	flag.IntVar(&batchSize, "batch", 16, "evaluation batch size")
+	flag.Parse()
+
+	if dirPath == "" || netPath == "" || outPath == "" {
+		essentials.Die("Required flags: -dir, -net, and -out. See -help for more.")
+	}
+
+	model, err := autorot.LoadEvaluator(netPath)
+	if err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	modelID, err := autorot.HashFile(netPath)
+	if err != nil {
+		essentials.Die("Hash network failed:", err)
+	}
+	var ensemble *autorot.Ensemble
+	switch method {
+	case "confidence":
+	case "disagreement":
+		var ok bool
+		ensemble, ok = model.(*autorot.Ensemble)
+		if !ok || len(ensemble.Nets) < 2 {
+			essentials.Die("The disagreement method requires an ensemble of at least two nets.")
+		}
+	default:
+		essentials.Die("Unknown method:", method)
+	}
+
+	excluded := map[string]bool{}
+	if excludePath != "" {
+		labels, err := autorot.ReadLabels(excludePath)
+		if err != nil {
+			essentials.Die("Read manifest failed:", err)
+		}
+		for _, l := range labels {
+			excluded[l.Path] = true
+		}
+	}
+
+	listing, err := autorot.ReadSampleList(0, dirPath)
+	if err != nil {
+		essentials.Die("Directory listing failed:", err)
+	}
+	var paths []string
+	for _, path := range listing.Paths {
+		if !excluded[path] {
+			paths = append(paths, path)
+		}
+	}
+
+	var candidates []*candidate
+	for i := 0; i < len(paths); i += batchSize {
+		end := i + batchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		candidates = append(candidates, scoreBatch(model, ensemble, paths[i:end])...)
+		log.Printf("Scored %d/%d images", end, len(paths))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+
+	if err := writeCandidates(outPath, format, modelID, candidates); err != nil {
+		essentials.Die("Write output failed:", err)
+	}
+}
+
+// scoreBatch evaluates images and scores their
+// uncertainty.
+// If ensemble is non-nil, disagreement is used as the
+// score.
+func scoreBatch(model autorot.Evaluator, ensemble *autorot.Ensemble,
+	paths []string) []*candidate {
+	var records []*autorot.Record
+	var imgs []image.Image
+	for _, path := range paths {
+		img, format, err := autorot.ReadImage(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		records = append(records, &autorot.Record{
+			Path:   path,
+			Width:  img.Bounds().Dx(),
+			Height: img.Bounds().Dy(),
+			Format: format,
+		})
+		imgs = append(imgs, img)
+	}
+	if len(imgs) == 0 {
+		return nil
+	}
+	var angles, confidences, disagreements []float64
+	if ensemble != nil {
+		angles, confidences, disagreements = ensemble.EvaluateDisagreement(imgs)
+	} else {
+		angles, confidences = model.EvaluateBatch(imgs)
+	}
+	res := make([]*candidate, len(records))
+	for i, rec := range records {
+		rec.SetPrediction(angles[i], confidences[i])
+		res[i] = &candidate{Record: rec, Score: 1 - confidences[i]}
+		if disagreements != nil {
+			res[i].Score = disagreements[i]
+		}
+	}
+	return res
+}
+
+func writeCandidates(path, format, modelID string, candidates []*candidate) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := autorot.NewRecordWriter(f, format)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteHeader(); err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		c.Record.Model = modelID
+		info, err := os.Stat(c.Record.Path)
+		if err == nil {
+			c.Record.Size = info.Size()
+			c.Record.ModTime = info.ModTime().UnixNano()
+		}
+		if err := w.Write(c.Record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"image"
+	"sync/atomic"
+	"time"
+)
+
+// A ProbEvaluator predicts rotations along with right
+// angle probabilities.
+// Both *autorot.Net and *autorot.Ensemble implement it.
+type ProbEvaluator interface {
+	EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+		probs [][4]float64)
+}
+
+// A Result is the prediction for a single image.
+type Result struct {
+	Angle      float64
+	Confidence float64
+	Probs      [4]float64
+
+	// Model is the model which made the prediction.
+	Model *ModelInfo
+}
+
+type batchRequest struct {
+	Ctx   context.Context
+	Image image.Image
+	Res   chan Result
+}
+
+// A Batcher groups concurrent requests into batches so
+// that the evaluator is used efficiently.
+//
+// Each evaluator of a model is used by one goroutine at a
+// time, since nets are not safe for concurrent use.
+type Batcher struct {
+	requests chan *batchRequest
+	maxBatch int
+	wait     time.Duration
+	model    atomic.Value
+}
+
+// NewBatcher starts one evaluation loop per evaluator of
+// the model.
+//
+// A batch is evaluated once it has maxBatch images or once
+// wait has elapsed since its first image arrived.
+func NewBatcher(m *Model, maxBatch int, wait time.Duration) *Batcher {
+	b := &Batcher{
+		requests: make(chan *batchRequest, maxBatch*len(m.Evaluators)),
+		maxBatch: maxBatch,
+		wait:     wait,
+	}
+	b.model.Store(m)
+	for i := range m.Evaluators {
+		go b.loop(i)
+	}
+	return b
+}
+
+// Model returns the active model.
+func (b *Batcher) Model() *Model {
+	return b.model.Load().(*Model)
+}
+
+// SetModel atomically replaces the active model.
+// Batches which are already being evaluated finish with
+// the old model.
+//
+// The new model should have as many evaluators as the
+// original one.
+func (b *Batcher) SetModel(m *Model) {
+	b.model.Store(m)
+}
+
+// Evaluate predicts the rotation of an image, waiting for
+// it to be evaluated as part of a batch.
+func (b *Batcher) Evaluate(ctx context.Context, img image.Image) (Result, error) {
+	req := &batchRequest{Ctx: ctx, Image: img, Res: make(chan Result, 1)}
+	select {
+	case b.requests <- req:
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+	select {
+	case res := <-req.Res:
+		return res, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+func (b *Batcher) loop(idx int) {
+	for first := range b.requests {
+		batch := []*batchRequest{first}
+		timer := time.NewTimer(b.wait)
+	CollectLoop:
+		for len(batch) < b.maxBatch {
+			select {
+			case req := <-b.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break CollectLoop
+			}
+		}
+		timer.Stop()
+		m := b.Model()
+		b.evaluate(m, m.Evaluators[idx%len(m.Evaluators)], batch)
+	}
+}
+
+func (b *Batcher) evaluate(m *Model, e ProbEvaluator, batch []*batchRequest) {
+	var live []*batchRequest
+	var imgs []image.Image
+	for _, req := range batch {
+		// Skip requests which timed out while queued.
+		if req.Ctx.Err() == nil {
+			live = append(live, req)
+			imgs = append(imgs, req.Image)
+		}
+	}
+	if len(imgs) == 0 {
+		return
+	}
+	angles, confidences, probs := e.EvaluateProbs(imgs)
+	for i, req := range live {
+		req.Res <- Result{
+			Angle:      angles[i],
+			Confidence: confidences[i],
+			Probs:      probs[i],
+			Model:      m.Info,
+		}
+	}
+}
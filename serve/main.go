@@ -0,0 +1,80 @@
+// Command serve runs an HTTP server which classifies
+// uploaded images.
+//
+// Concurrent requests are grouped into batches before
+// they are passed to the network.
+// See Server for the API.
+//
+// A new network can be swapped in without downtime, either
+// by watching the network file or through an admin
+// endpoint.
+// New networks are validated before they are used.
+package main
+
+import (
+	"flag"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	var netPath string
+	var addr string
+	var server Server
+	var batchSize int
+	var batchWait time.Duration
+	var workers int
+	var watchInterval time.Duration
+	var cpuPlan bool
+	flag.StringVar(&netPath, "net", "", "network or ensemble path")
+	flag.StringVar(&addr, "addr", ":8080", "address to listen on")
+	flag.Int64Var(&server.MaxBytes, "max-bytes", 32<<20, "maximum request size")
+	flag.Int64Var(&server.MaxPixels, "max-pixels", 100e6,
+		"maximum image width times height (0 for no limit)")
+	flag.DurationVar(&server.Timeout, "timeout", 30*time.Second, "per-request timeout")
+	flag.IntVar(&server.Quality, "quality", 95, "JPEG quality for corrected images")
+	flag.IntVar(&batchSize, "batch", 16, "maximum evaluation batch size")
+	flag.DurationVar(&batchWait, "batch-wait", 10*time.Millisecond,
+		"maximum time to wait for a batch to fill")
+	flag.IntVar(&workers, "workers", 1, "number of concurrent batches (one network copy each)")
+	flag.DurationVar(&watchInterval, "watch", 0,
+		"interval for polling the network file for changes (0 to disable)")
+	flag.StringVar(&server.AdminToken, "admin-token", "",
+		"bearer token for the reload endpoint (disabled if empty)")
+	flag.BoolVar(&cpuPlan, "cpu-plan", false, "evaluate nets with compiled CPU inference plans")
+	flag.Parse()
+
+	if netPath == "" {
+		essentials.Die("Required flags: -net. See -help for more.")
+	}
+	if batchSize < 1 || workers < 1 {
+		essentials.Die("Batch size and worker count must be at least 1.")
+	}
+
+	server.Load = func() (*Model, error) {
+		return LoadModel(netPath, workers, cpuPlan)
+	}
+	model, err := server.Load()
+	if err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	server.Batcher = NewBatcher(model, batchSize, batchWait)
+	if watchInterval > 0 {
+		go server.WatchModel(netPath, watchInterval)
+	}
+
+	httpServer := &http.Server{
+		Addr:        addr,
+		Handler:     &server,
+		ReadTimeout: server.Timeout,
+	}
+	log.Println("Listening on", addr)
+	if err := httpServer.ListenAndServe(); err != nil {
+		essentials.Die(err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+
+	"github.com/unixpickle/autorot"
+)
+
+// A Model is a loaded network along with one copy of it
+// for each evaluation loop.
+type Model struct {
+	Info       *ModelInfo
+	Evaluators []ProbEvaluator
+}
+
+// ModelInfo describes a loaded model in responses.
+type ModelInfo struct {
+	Hash     string    `json:"hash"`
+	Path     string    `json:"path,omitempty"`
+	LoadedAt time.Time `json:"loaded_at"`
+
+	// The remaining fields describe the net, or the first
+	// net of an ensemble.
+	OutputType  string    `json:"output_type,omitempty"`
+	InputSize   int       `json:"input_size,omitempty"`
+	Created     time.Time `json:"created,omitempty"`
+	Iterations  int       `json:"iterations,omitempty"`
+	DatasetHash string    `json:"dataset_hash,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+}
+
+// LoadModel loads, copies, and validates a model file.
+//
+// If cpuPlan is set, nets are evaluated with compiled CPU
+// inference plans, which are shared between the copies.
+func LoadModel(path string, workers int, cpuPlan bool) (*Model, error) {
+	// The file is read once so that the hash matches the
+	// model even if the file is replaced while loading.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	model, err := autorot.DeserializeEvaluator(data)
+	if err != nil {
+		return nil, err
+	}
+	if cpuPlan {
+		if err := autorot.CompileEvaluator(model); err != nil {
+			return nil, err
+		}
+	}
+	info := &ModelInfo{Hash: autorot.HashBytes(data), Path: path, LoadedAt: time.Now()}
+	var net *autorot.Net
+	switch model := model.(type) {
+	case *autorot.Net:
+		net = model
+	case *autorot.Ensemble:
+		net = model.Nets[0]
+	}
+	if net != nil {
+		info.OutputType = net.OutputType.String()
+		info.InputSize = net.InputSize
+		info.Created = net.Metadata.Created
+		info.Iterations = net.Metadata.Iterations
+		info.DatasetHash = net.Metadata.DatasetHash
+		info.Notes = net.Metadata.Notes
+	}
+	res := &Model{Info: info}
+	for i := 0; i < workers; i++ {
+		workerModel := model
+		if i > 0 {
+			workerModel, err = autorot.CloneEvaluator(model)
+			if err != nil {
+				return nil, err
+			}
+		}
+		e, ok := workerModel.(ProbEvaluator)
+		if !ok {
+			return nil, fmt.Errorf("load model: unsupported type %T", workerModel)
+		}
+		res.Evaluators = append(res.Evaluators, e)
+	}
+	if err := ValidateModel(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ValidateModel runs a smoke input through every copy of
+// a model and checks that the outputs are well-formed.
+func ValidateModel(m *Model) error {
+	if len(m.Evaluators) == 0 {
+		return errors.New("validate model: no evaluators")
+	}
+	imgs := []image.Image{smokeImage(64, 48), smokeImage(48, 64)}
+	for _, e := range m.Evaluators {
+		angles, confidences, probs := e.EvaluateProbs(imgs)
+		if len(angles) != len(imgs) || len(confidences) != len(imgs) ||
+			len(probs) != len(imgs) {
+			return errors.New("validate model: wrong number of outputs")
+		}
+		for i := range imgs {
+			if math.IsNaN(angles[i]) || math.IsInf(angles[i], 0) {
+				return errors.New("validate model: invalid angle")
+			}
+			if !(confidences[i] >= 0 && confidences[i] <= 1) {
+				return errors.New("validate model: invalid confidence")
+			}
+			var sum float64
+			for _, p := range probs[i] {
+				sum += p
+			}
+			if !(math.Abs(sum-1) < 1e-3) {
+				return errors.New("validate model: probabilities do not sum to 1")
+			}
+		}
+	}
+	return nil
+}
+
+func smokeImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(x * 255 / width),
+				G: uint8(y * 255 / height),
+				B: 0x80,
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
+
+// modelStamp identifies a version of a model file.
+type modelStamp struct {
+	Size    int64
+	ModTime time.Time
+}
+
+func statModel(path string) (modelStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return modelStamp{}, err
+	}
+	return modelStamp{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/autorot"
+)
+
+// A Server exposes a Batcher over HTTP.
+//
+// Endpoints:
+//
+//	GET  /health        reports that the server is up
+//	GET  /model         describes the active model
+//	POST /classify      classifies an image
+//	POST /admin/reload  reloads the model
+//
+// Images may be posted as the raw request body or as the
+// "image" field of a multipart form.
+// If the "correct" query parameter is true, the response
+// includes the corrected image, snapped to a right angle
+// unless "snap" is false.
+//
+// The reload endpoint is only enabled if AdminToken is
+// set, in which case it must be passed as a bearer token.
+type Server struct {
+	Batcher *Batcher
+
+	// Load loads a new copy of the model for reloads.
+	Load       func() (*Model, error)
+	AdminToken string
+
+	// MaxBytes limits the size of request bodies.
+	MaxBytes int64
+
+	// MaxPixels, if non-zero, limits the dimensions of
+	// uploaded images, since a small compressed file can
+	// decode to a huge image.
+	MaxPixels int64
+
+	// Timeout limits the time spent on each request,
+	// including time spent waiting for a batch.
+	Timeout time.Duration
+
+	// Quality is used to encode corrected JPEGs.
+	Quality int
+
+	reloadLock sync.Mutex
+}
+
+// A Response is the JSON result of a classification.
+type Response struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+
+	// Angle is the predicted clockwise rotation in radians.
+	Angle      float64    `json:"angle"`
+	Degrees    float64    `json:"degrees"`
+	RightAngle int        `json:"right_angle"`
+	Confidence float64    `json:"confidence"`
+	Probs      [4]float64 `json:"probs"`
+	Model      *ModelInfo `json:"model"`
+
+	// CorrectedImage is encoded as base64 in JSON.
+	CorrectedImage  []byte `json:"corrected_image,omitempty"`
+	CorrectedFormat string `json:"corrected_format,omitempty"`
+}
+
+// ServeHTTP routes requests to the endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/health":
+		s.serveHealth(w, r)
+	case "/model":
+		writeJSON(w, http.StatusOK, s.Batcher.Model().Info)
+	case "/classify":
+		s.serveClassify(w, r)
+	case "/admin/reload":
+		s.serveReload(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"model":  s.Batcher.Model().Info,
+	})
+}
+
+func (s *Server) serveReload(w http.ResponseWriter, r *http.Request) {
+	if s.AdminToken == "" || s.Load == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	token := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+s.AdminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	info, err := s.Reload()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// Reload loads and validates a new model and swaps it in.
+// If loading fails, the active model is left in place.
+func (s *Server) Reload() (*ModelInfo, error) {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	m, err := s.Load()
+	if err != nil {
+		return nil, errors.New("reload: " + err.Error())
+	}
+	s.Batcher.SetModel(m)
+	log.Printf("Loaded model %s", m.Info.Hash)
+	return m.Info, nil
+}
+
+// WatchModel polls a model file and reloads the model when
+// the file changes.
+//
+// A change is only acted upon once the file has stopped
+// changing between two polls, so that partially written
+// files are not loaded.
+// This never returns.
+func (s *Server) WatchModel(path string, interval time.Duration) {
+	loaded, _ := statModel(path)
+	last := loaded
+	for {
+		time.Sleep(interval)
+		stamp, err := statModel(path)
+		if err != nil {
+			continue
+		}
+		if stamp != last {
+			last = stamp
+			continue
+		}
+		if stamp != loaded {
+			loaded = stamp
+			if _, err := s.Reload(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func (s *Server) serveClassify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxBytes)
+	data, err := readUpload(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			writeError(w, http.StatusRequestEntityTooLarge, "image too large")
+		} else {
+			writeError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "decode image: "+err.Error())
+		return
+	}
+	if s.MaxPixels != 0 && int64(config.Width)*int64(config.Height) > s.MaxPixels {
+		writeError(w, http.StatusRequestEntityTooLarge, "image dimensions too large")
+		return
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "decode image: "+err.Error())
+		return
+	}
+
+	result, err := s.Batcher.Evaluate(ctx, img)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			writeError(w, http.StatusGatewayTimeout, "timed out")
+		} else {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+		}
+		return
+	}
+
+	resp := &Response{
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+		Format:     format,
+		Angle:      result.Angle,
+		Degrees:    result.Angle * 180 / math.Pi,
+		RightAngle: autorot.NearestRightAngle(result.Angle),
+		Confidence: result.Confidence,
+		Probs:      result.Probs,
+		Model:      result.Model,
+	}
+	if queryBool(r, "correct", false) {
+		if format != "jpeg" {
+			format = "png"
+		}
+		var exif []byte
+		if format == "jpeg" {
+			exif, _ = autorot.JPEGExif(data)
+		}
+		corrected := autorot.Correct(img, result.Angle, queryBool(r, "snap", true))
+		var buf bytes.Buffer
+		if err := autorot.EncodeImage(&buf, corrected, format, s.Quality, exif); err != nil {
+			writeError(w, http.StatusInternalServerError, "encode image: "+err.Error())
+			return
+		}
+		resp.CorrectedImage = buf.Bytes()
+		resp.CorrectedFormat = format
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// readUpload reads the image data from a raw body or a
+// multipart form.
+func readUpload(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return ioutil.ReadAll(r.Body)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("missing image field")
+		} else if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "image" {
+			return ioutil.ReadAll(part)
+		}
+	}
+}
+
+func queryBool(r *http.Request, name string, defaultValue bool) bool {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue
+	}
+	res, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
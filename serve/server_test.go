@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEvaluator predicts a 90 degree rotation for wide
+// images and no rotation for others.
+type fakeEvaluator struct {
+	lock       sync.Mutex
+	batchSizes []int
+	delay      time.Duration
+}
+
+func (f *fakeEvaluator) EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+	probs [][4]float64) {
+	time.Sleep(f.delay)
+	f.lock.Lock()
+	f.batchSizes = append(f.batchSizes, len(imgs))
+	f.lock.Unlock()
+	for _, img := range imgs {
+		if img.Bounds().Dx() > img.Bounds().Dy() {
+			angles = append(angles, math.Pi/2)
+			probs = append(probs, [4]float64{0.1, 0.7, 0.1, 0.1})
+		} else {
+			angles = append(angles, 0)
+			probs = append(probs, [4]float64{0.7, 0.1, 0.1, 0.1})
+		}
+		confidences = append(confidences, 0.7)
+	}
+	return
+}
+
+func (f *fakeEvaluator) maxBatchSize() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var res int
+	for _, size := range f.batchSizes {
+		if size > res {
+			res = size
+		}
+	}
+	return res
+}
+
+func testModel(hash string, e ProbEvaluator) *Model {
+	return &Model{
+		Info:       &ModelInfo{Hash: hash},
+		Evaluators: []ProbEvaluator{e},
+	}
+}
+
+func testServer(e *fakeEvaluator, batchWait time.Duration) *httptest.Server {
+	return httptest.NewServer(&Server{
+		Batcher:   NewBatcher(testModel("test-model", e), 8, batchWait),
+		MaxBytes:  1 << 16,
+		MaxPixels: 1 << 20,
+		Timeout:   time.Second,
+		Quality:   90,
+	})
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 10), G: uint8(y * 10), A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeResponse(t *testing.T, resp *http.Response) *Response {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	var res Response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return &res
+}
+
+func TestServerHealth(t *testing.T) {
+	server := testServer(&fakeEvaluator{}, time.Millisecond)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+}
+
+func TestServerClassifyRaw(t *testing.T) {
+	server := testServer(&fakeEvaluator{}, time.Millisecond)
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/classify", "image/png",
+		bytes.NewReader(testPNG(t, 6, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := decodeResponse(t, resp)
+	if res.Width != 6 || res.Height != 4 || res.Format != "png" {
+		t.Errorf("unexpected image info: %dx%d %s", res.Width, res.Height, res.Format)
+	}
+	if res.RightAngle != 1 || math.Abs(res.Degrees-90) > 1e-8 {
+		t.Errorf("unexpected angle: %f", res.Degrees)
+	}
+	if res.Confidence != 0.7 || res.Probs[1] != 0.7 {
+		t.Errorf("unexpected confidence or probs: %f %v", res.Confidence, res.Probs)
+	}
+	if res.Model == nil || res.Model.Hash != "test-model" {
+		t.Errorf("unexpected model: %v", res.Model)
+	}
+	if res.CorrectedImage != nil {
+		t.Error("unexpected corrected image")
+	}
+}
+
+func TestServerClassifyMultipart(t *testing.T) {
+	server := testServer(&fakeEvaluator{}, time.Millisecond)
+	defer server.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("name", "photo")
+	part, err := w.CreateFormFile("image", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(testPNG(t, 6, 4))
+	w.Close()
+
+	resp, err := http.Post(server.URL+"/classify?correct=true", w.FormDataContentType(),
+		&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := decodeResponse(t, resp)
+	if res.CorrectedFormat != "png" {
+		t.Fatalf("unexpected corrected format: %s", res.CorrectedFormat)
+	}
+	corrected, err := png.Decode(bytes.NewReader(res.CorrectedImage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corrected.Bounds().Dx() != 4 || corrected.Bounds().Dy() != 6 {
+		t.Errorf("unexpected corrected bounds: %v", corrected.Bounds())
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	server := testServer(&fakeEvaluator{}, time.Millisecond)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/classify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: unexpected status %s", resp.Status)
+	}
+
+	resp, err = http.Post(server.URL+"/classify", "image/png",
+		bytes.NewReader([]byte("not an image")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad image: unexpected status %s", resp.Status)
+	}
+
+	resp, err = http.Post(server.URL+"/classify", "image/png",
+		bytes.NewReader(make([]byte, 1<<17)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: unexpected status %s", resp.Status)
+	}
+
+	resp, err = http.Post(server.URL+"/classify", "image/png",
+		bytes.NewReader(resizedPNG(t, testPNG(t, 4, 4), 50000, 50000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("large dimensions: unexpected status %s", resp.Status)
+	}
+}
+
+// resizedPNG changes the dimensions in the header of a PNG
+// without changing its pixel data.
+func resizedPNG(t *testing.T, data []byte, width, height int) []byte {
+	// The IHDR chunk follows the 8-byte signature, and its
+	// data starts with the width and height.
+	data = append([]byte{}, data...)
+	if string(data[12:16]) != "IHDR" {
+		t.Fatal("missing IHDR chunk")
+	}
+	binary.BigEndian.PutUint32(data[16:], uint32(width))
+	binary.BigEndian.PutUint32(data[20:], uint32(height))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestServerTimeout(t *testing.T) {
+	server := httptest.NewServer(&Server{
+		Batcher: NewBatcher(testModel("slow-model", &fakeEvaluator{delay: time.Second}),
+			8, 0),
+		MaxBytes: 1 << 16,
+		Timeout:  50 * time.Millisecond,
+	})
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/classify", "image/png",
+		bytes.NewReader(testPNG(t, 4, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+func TestServerBatching(t *testing.T) {
+	evaluator := &fakeEvaluator{}
+	server := testServer(evaluator, 200*time.Millisecond)
+	defer server.Close()
+
+	data := testPNG(t, 4, 6)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(server.URL+"/classify", "image/png", bytes.NewReader(data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status %s", resp.Status)
+			}
+		}()
+	}
+	wg.Wait()
+	if size := evaluator.maxBatchSize(); size < 2 {
+		t.Errorf("requests were not batched (max batch size %d)", size)
+	}
+}
+
+// brokenEvaluator produces invalid probabilities.
+type brokenEvaluator struct{}
+
+func (b brokenEvaluator) EvaluateProbs(imgs []image.Image) (angles, confidences []float64,
+	probs [][4]float64) {
+	for range imgs {
+		angles = append(angles, math.NaN())
+		confidences = append(confidences, 0.5)
+		probs = append(probs, [4]float64{})
+	}
+	return
+}
+
+func TestServerReload(t *testing.T) {
+	var nextModel *Model
+	s := &Server{
+		Batcher:    NewBatcher(testModel("old-model", &fakeEvaluator{}), 8, time.Millisecond),
+		AdminToken: "secret",
+		Load: func() (*Model, error) {
+			if err := ValidateModel(nextModel); err != nil {
+				return nil, err
+			}
+			return nextModel, nil
+		},
+		MaxBytes: 1 << 16,
+		Timeout:  time.Second,
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	reload := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/admin/reload", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	activeModel := func() string {
+		resp, err := http.Post(server.URL+"/classify", "image/png",
+			bytes.NewReader(testPNG(t, 4, 4)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decodeResponse(t, resp).Model.Hash
+	}
+
+	nextModel = testModel("new-model", &fakeEvaluator{})
+	if status := reload("wrong"); status != http.StatusUnauthorized {
+		t.Errorf("bad token: unexpected status %d", status)
+	}
+	if hash := activeModel(); hash != "old-model" {
+		t.Errorf("expected old-model but got %s", hash)
+	}
+
+	if status := reload("secret"); status != http.StatusOK {
+		t.Errorf("reload: unexpected status %d", status)
+	}
+	if hash := activeModel(); hash != "new-model" {
+		t.Errorf("expected new-model but got %s", hash)
+	}
+
+	nextModel = testModel("broken-model", brokenEvaluator{})
+	if status := reload("secret"); status != http.StatusUnprocessableEntity {
+		t.Errorf("broken reload: unexpected status %d", status)
+	}
+	if hash := activeModel(); hash != "new-model" {
+		t.Errorf("expected new-model to remain but got %s", hash)
+	}
+}
@@ -22,16 +22,25 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 	var netFile string
 	var dataDir string
+	var manifestPath string
+	var hardPath string
+	var hardRatio float64
+	var headOnly bool
 	var stepSize float64
 	var batchSize int
 	flag.StringVar(&netFile, "net", "", "network file")
-	flag.StringVar(&dataDir, "data", "", "image directory")
+	flag.StringVar(&dataDir, "data", "", "directory of upright images")
+	flag.StringVar(&manifestPath, "manifest", "", "label manifest (e.g. from the review command)")
+	flag.StringVar(&hardPath, "hard", "", "label manifest of hard examples to oversample")
+	flag.Float64Var(&hardRatio, "hard-ratio", 0.25,
+		"fraction of each batch drawn from -hard")
 	flag.Float64Var(&stepSize, "step", 0.001, "SGD step size")
 	flag.IntVar(&batchSize, "batch", 12, "SGD batch size")
+	flag.BoolVar(&headOnly, "head-only", false, "only train the head, keeping the backbone fixed")
 	flag.Parse()
 
-	if netFile == "" || dataDir == "" {
-		essentials.Die("Required flags: -net and -data. See -help for more.")
+	if netFile == "" || (dataDir == "" && manifestPath == "") {
+		essentials.Die("Required flags: -net and -data (or -manifest). See -help for more.")
 	}
 
 	log.Println("Loading network...")
@@ -43,17 +52,56 @@ func main() {
 
 	log.Println("Loading samples...")
 
-	samples, err := autorot.ReadSampleList(net.InputSize, dataDir)
-	if err != nil {
-		essentials.Die("Load data failed:", err)
+	samples := &autorot.SampleList{ImageSize: net.InputSize}
+	if dataDir != "" {
+		dirSamples, err := autorot.ReadSampleList(net.InputSize, dataDir)
+		if err != nil {
+			essentials.Die("Load data failed:", err)
+		}
+		samples.Append(dirSamples)
+	}
+	if manifestPath != "" {
+		labeled, err := autorot.ReadManifest(net.InputSize, manifestPath)
+		if err != nil {
+			essentials.Die("Load manifest failed:", err)
+		}
+		samples.Append(labeled)
+	}
+	samples.InputMode = net.InputMode
+
+	var trainSamples anyff.SampleList = samples
+	datasetHash := samples.Hash()
+	if hardPath != "" {
+		if hardRatio < 0 || hardRatio >= 1 {
+			essentials.Die("Hard example ratio must be in [0, 1).")
+		}
+		hard, err := autorot.ReadManifest(net.InputSize, hardPath)
+		if err != nil {
+			essentials.Die("Load hard examples failed:", err)
+		}
+		hard.InputMode = net.InputMode
+		mixed := autorot.NewMixedSampleList(samples, hard, hardRatio, batchSize)
+		log.Printf("Mixing %d of %d hard examples into each batch of %d samples.",
+			mixed.HardPerBatch, len(hard.Paths), batchSize)
+		trainSamples = mixed
+		datasetHash = mixed.Hash()
 	}
 
 	log.Println("Training...")
 
+	autorot.SetDropout(net.Net, true)
+
+	params := net.Net.Parameters()
+	if headOnly {
+		head := net.Net[len(net.Net)-net.HeadLength():]
+		params = head.Parameters()
+		log.Printf("Training %d head layers.", len(head))
+	}
+
 	t := &anyff.Trainer{
 		Net:     net.Net,
 		Cost:    net,
-		Params:  net.Net.Parameters(),
+		Params:  params,
 		Average: true,
 	}
 
@@ -62,7 +110,7 @@ func main() {
 		Fetcher:     t,
 		Gradienter:  t,
 		Transformer: &anysgd.Adam{},
-		Samples:     samples,
+		Samples:     trainSamples,
 		Rater:       anysgd.ConstRater(stepSize),
 		BatchSize:   batchSize,
 		StatusFunc: func(b anysgd.Batch) {
@@ -73,6 +121,10 @@ func main() {
 
 	s.Run(rip.NewRIP().Chan())
 
+	autorot.SetDropout(net.Net, false)
+	net.Metadata.Iterations += iterNum
+	net.Metadata.DatasetHash = datasetHash
+
 	log.Println("Saving network...")
 	if err := serializer.SaveAny(netFile, net); err != nil {
 		essentials.Die("Save failed:", err)
@@ -0,0 +1,137 @@
+// Command undo reverts the changes recorded in a journal
+// by the fix command.
+//
+// Entries are reverted newest first.
+// A file is only restored if it still matches the hash
+// recorded after the change, unless -force is passed.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	var journalPath string
+	var force bool
+	var dryRun bool
+	flag.StringVar(&journalPath, "journal", "", "journal path")
+	flag.BoolVar(&force, "force", false, "restore files that changed since they were fixed")
+	flag.BoolVar(&dryRun, "dry-run", false, "check entries without restoring files")
+	flag.Parse()
+
+	if journalPath == "" {
+		essentials.Die("Required flags: -journal. See -help for more.")
+	}
+
+	entries, err := autorot.ReadJournal(journalPath)
+	if err != nil {
+		essentials.Die("Read journal failed:", err)
+	}
+
+	var numRestored, numFailed int
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if err := undoEntry(entry, force, dryRun); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			numFailed++
+		} else {
+			numRestored++
+		}
+	}
+	verb := "Restored"
+	if dryRun {
+		verb = "Could restore"
+	}
+	log.Printf("%s %d of %d files (%d failed).", verb, numRestored, len(entries),
+		numFailed)
+	if numFailed > 0 {
+		os.Exit(1)
+	}
+}
+
+func undoEntry(entry *autorot.JournalEntry, force, dryRun bool) error {
+	data, err := ioutil.ReadFile(entry.Path)
+	if err != nil {
+		return errors.New("undo: " + err.Error())
+	}
+	hash := autorot.HashBytes(data)
+	if hash == entry.OriginalHash {
+		// Already restored, e.g. by an earlier run.
+		if !dryRun {
+			removeBackup(entry)
+		}
+		return nil
+	} else if hash != entry.NewHash && !force {
+		return errors.New("undo " + entry.Path + ": file changed since it was fixed")
+	}
+
+	var restored []byte
+	switch entry.Transform {
+	case autorot.ExifTransform:
+		restored = append([]byte{}, data...)
+		exif, offset := autorot.JPEGExif(restored)
+		if exif == nil {
+			return errors.New("undo " + entry.Path + ": missing EXIF data")
+		}
+		payload := restored[offset : offset+len(exif)]
+		if err := autorot.SetExifOrientation(payload, entry.OldOrientation); err != nil {
+			return errors.New("undo " + entry.Path + ": " + err.Error())
+		}
+	case autorot.RewriteTransform:
+		restored, err = ioutil.ReadFile(entry.Backup)
+		if err != nil {
+			return errors.New("undo " + entry.Path + ": read backup: " + err.Error())
+		}
+	default:
+		return errors.New("undo " + entry.Path + ": unknown transform " + entry.Transform)
+	}
+
+	if autorot.HashBytes(restored) != entry.OriginalHash {
+		return errors.New("undo " + entry.Path + ": restored data does not match original")
+	}
+	if dryRun {
+		return nil
+	}
+
+	info, err := os.Stat(entry.Path)
+	if err != nil {
+		return errors.New("undo: " + err.Error())
+	}
+	tempPath := entry.Path + ".autorot-tmp"
+	if err := ioutil.WriteFile(tempPath, restored, info.Mode()); err != nil {
+		return errors.New("undo: " + err.Error())
+	}
+	if err := os.Rename(tempPath, entry.Path); err != nil {
+		os.Remove(tempPath)
+		return errors.New("undo: " + err.Error())
+	}
+	log.Printf("%s: restored", entry.Path)
+	removeBackup(entry)
+	return nil
+}
+
+// removeBackup deletes the backup of a restored file, so
+// that the file can be fixed again later.
+//
+// The backup is kept if it does not hold the original
+// data, since it may belong to a different change.
+func removeBackup(entry *autorot.JournalEntry) {
+	if entry.Transform != autorot.RewriteTransform || entry.Backup == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(entry.Backup)
+	if err != nil || autorot.HashBytes(data) != entry.OriginalHash {
+		return
+	}
+	if err := os.Remove(entry.Backup); err != nil {
+		fmt.Fprintln(os.Stderr, "undo: remove backup:", err)
+	}
+}
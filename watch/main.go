@@ -0,0 +1,247 @@
+// Command watch runs as a daemon which classifies images
+// as they appear in an inbox directory.
+//
+// The inbox is polled for new files.
+// Once a file has stopped changing, it is classified and
+// moved into one of three subdirectories of the output
+// directory:
+//
+//	done/    confident predictions, corrected if needed
+//	review/  low-confidence predictions, left untouched
+//	failed/  files that could not be read or corrected
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unixpickle/autorot"
+	"github.com/unixpickle/essentials"
+)
+
+// Output subdirectories.
+const (
+	DoneDir   = "done"
+	ReviewDir = "review"
+	FailedDir = "failed"
+)
+
+// A Watcher classifies and sorts the files in an inbox.
+type Watcher struct {
+	Model autorot.Evaluator
+
+	Inbox  string
+	OutDir string
+
+	// Threshold is the minimum confidence for a file to be
+	// corrected rather than sent to review.
+	Threshold float64
+
+	// Mode is an autorot.CorrectFile mode, or "none" to
+	// sort files without correcting them.
+	Mode    string
+	Quality int
+
+	// pending tracks files which were still changing on the
+	// last poll.
+	pending map[string]fileStamp
+}
+
+type fileStamp struct {
+	Size    int64
+	ModTime time.Time
+}
+
+func main() {
+	var w Watcher
+	var netPath string
+	var interval time.Duration
+	flag.StringVar(&netPath, "net", "", "network or ensemble path")
+	flag.StringVar(&w.Inbox, "inbox", "", "directory to watch")
+	flag.StringVar(&w.OutDir, "out", "", "directory for sorted files (default: the inbox)")
+	flag.Float64Var(&w.Threshold, "threshold", 0.9, "minimum confidence for corrections")
+	flag.StringVar(&w.Mode, "mode", autorot.AutoMode,
+		"correction method (auto, exif, rewrite, or none)")
+	flag.IntVar(&w.Quality, "quality", 95, "JPEG quality for rewritten images")
+	flag.DurationVar(&interval, "interval", 2*time.Second, "polling interval")
+	flag.Parse()
+
+	if netPath == "" || w.Inbox == "" {
+		essentials.Die("Required flags: -net and -inbox. See -help for more.")
+	}
+	switch w.Mode {
+	case autorot.AutoMode, autorot.ExifMode, autorot.RewriteMode, "none":
+	default:
+		essentials.Die("Unknown mode:", w.Mode)
+	}
+	if w.OutDir == "" {
+		w.OutDir = w.Inbox
+	}
+	for _, dir := range []string{DoneDir, ReviewDir, FailedDir} {
+		if err := os.MkdirAll(filepath.Join(w.OutDir, dir), 0755); err != nil {
+			essentials.Die(err)
+		}
+	}
+
+	var err error
+	w.Model, err = autorot.LoadEvaluator(netPath)
+	if err != nil {
+		essentials.Die("Load network failed:", err)
+	}
+	if w.Threshold > 0 && !autorot.HasConfidence(w.Model) {
+		essentials.Die("The network does not produce confidences (RawAngle outputs), " +
+			"so -threshold must be 0.")
+	}
+
+	log.Println("Watching", w.Inbox)
+	for {
+		if err := w.Poll(); err != nil {
+			log.Println("Poll failed:", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Poll processes every file in the inbox that has not
+// changed since the previous poll.
+func (w *Watcher) Poll() error {
+	listing, err := ioutil.ReadDir(w.Inbox)
+	if err != nil {
+		return err
+	}
+	stillPending := map[string]fileStamp{}
+	for _, info := range listing {
+		if info.IsDir() || !isImage(info.Name()) {
+			continue
+		}
+		path := filepath.Join(w.Inbox, info.Name())
+		stamp := fileStamp{Size: info.Size(), ModTime: info.ModTime()}
+		if old, ok := w.pending[path]; !ok || old != stamp {
+			// The file is new or still being written.
+			stillPending[path] = stamp
+			continue
+		}
+		w.Process(path)
+	}
+	w.pending = stillPending
+	return nil
+}
+
+// Process classifies a file and moves it to the output
+// directory, logging the decision.
+func (w *Watcher) Process(path string) {
+	name := filepath.Base(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		w.fail(path, err)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		w.fail(path, errors.New("decode: "+err.Error()))
+		return
+	}
+	angle, confidence := w.Model.Evaluate(img)
+	degrees := float64(autorot.NearestRightAngle(angle)) * 90
+	if confidence < w.Threshold {
+		if err := moveFile(path, filepath.Join(w.OutDir, ReviewDir, name)); err != nil {
+			w.fail(path, err)
+			return
+		}
+		log.Printf("%s: review (%.0f deg, confidence %.3f)", name, degrees, confidence)
+		return
+	}
+
+	action := "unchanged"
+	if w.Mode != "none" {
+		correction, err := autorot.CorrectFile(data, img, angle, w.Mode, w.Quality)
+		if err != nil {
+			w.fail(path, err)
+			return
+		} else if correction != nil {
+			action = correction.Transform
+			data = correction.Data
+		}
+	}
+	donePath := filepath.Join(w.OutDir, DoneDir, name)
+	if err := writeFile(donePath, data); err != nil {
+		w.fail(path, err)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("%s: remove from inbox: %v", name, err)
+	}
+	log.Printf("%s: done, %s (%.0f deg, confidence %.3f)", name, action, degrees, confidence)
+}
+
+func (w *Watcher) fail(path string, err error) {
+	name := filepath.Base(path)
+	log.Printf("%s: failed: %v", name, err)
+	if err := moveFile(path, filepath.Join(w.OutDir, FailedDir, name)); err != nil {
+		log.Printf("%s: move to %s: %v", name, FailedDir, err)
+	}
+}
+
+// moveFile renames a file without overwriting an existing
+// file at the destination.
+func moveFile(source, dest string) error {
+	dest, err := freePath(dest)
+	if err != nil {
+		return err
+	}
+	return os.Rename(source, dest)
+}
+
+// writeFile writes data to a temporary file and then
+// renames it into place, without overwriting an existing
+// file.
+func writeFile(dest string, data []byte) error {
+	dest, err := freePath(dest)
+	if err != nil {
+		return err
+	}
+	tempPath := dest + ".autorot-tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, dest); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// freePath finds a variant of a path which does not exist
+// yet by adding a numeric suffix.
+func freePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("no free path for " + path)
+}
+
+func isImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg" || ext == ".png"
+}
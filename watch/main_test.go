@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/unixpickle/autorot"
+)
+
+// fakeEvaluator predicts a 90 degree rotation for wide
+// images and no rotation for others.
+// Tall images get a low confidence.
+type fakeEvaluator struct{}
+
+func (f fakeEvaluator) Evaluate(img image.Image) (angle, confidence float64) {
+	size := img.Bounds().Size()
+	if size.X > size.Y {
+		return math.Pi / 2, 0.95
+	} else if size.X < size.Y {
+		return 0, 0.5
+	}
+	return 0, 0.95
+}
+
+func (f fakeEvaluator) EvaluateBatch(imgs []image.Image) (angles, confidences []float64) {
+	for _, img := range imgs {
+		angle, confidence := f.Evaluate(img)
+		angles = append(angles, angle)
+		confidences = append(confidences, confidence)
+	}
+	return
+}
+
+func (f fakeEvaluator) EvaluateTTA(imgs []image.Image,
+	t *autorot.TTA) (angles, confidences []float64) {
+	return f.EvaluateBatch(imgs)
+}
+
+func TestWatcherPoll(t *testing.T) {
+	w := testWatcher(t, autorot.AutoMode)
+	defer os.RemoveAll(w.OutDir)
+
+	writeTestPNG(t, filepath.Join(w.Inbox, "a.png"), 4, 4)
+	writeTestPNG(t, filepath.Join(w.Inbox, "b.png"), 4, 4)
+	if err := ioutil.WriteFile(filepath.Join(w.Inbox, "notes.txt"), []byte("hi"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, w.Inbox, "a.png", "b.png", "notes.txt")
+
+	// b.png is still being written, so it should wait for
+	// the next poll.
+	writeTestPNG(t, filepath.Join(w.Inbox, "b.png"), 6, 6)
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, w.Inbox, "b.png", "notes.txt")
+	checkFiles(t, filepath.Join(w.OutDir, DoneDir), "a.png")
+
+	if err := w.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, w.Inbox, "notes.txt")
+	checkFiles(t, filepath.Join(w.OutDir, DoneDir), "a.png", "b.png")
+}
+
+func TestWatcherProcess(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Mode   string
+		Width  int
+		Height int
+		Data   []byte
+
+		Dir string
+
+		// Size of the output image, if it is decodable.
+		OutWidth  int
+		OutHeight int
+	}{
+		{Name: "upright", Mode: autorot.AutoMode, Width: 4, Height: 4, Dir: DoneDir,
+			OutWidth: 4, OutHeight: 4},
+		{Name: "upright_exif", Mode: autorot.ExifMode, Width: 4, Height: 4, Dir: DoneDir,
+			OutWidth: 4, OutHeight: 4},
+		{Name: "rotated", Mode: autorot.RewriteMode, Width: 6, Height: 3, Dir: DoneDir,
+			OutWidth: 3, OutHeight: 6},
+		{Name: "rotated_none", Mode: "none", Width: 6, Height: 3, Dir: DoneDir,
+			OutWidth: 6, OutHeight: 3},
+		{Name: "rotated_exif", Mode: autorot.ExifMode, Width: 6, Height: 3, Dir: FailedDir,
+			OutWidth: 6, OutHeight: 3},
+		{Name: "unsure", Mode: autorot.AutoMode, Width: 3, Height: 6, Dir: ReviewDir,
+			OutWidth: 3, OutHeight: 6},
+		{Name: "corrupt", Mode: autorot.AutoMode, Data: []byte("not an image"),
+			Dir: FailedDir},
+	}
+	for _, test := range tests {
+		w := testWatcher(t, test.Mode)
+		path := filepath.Join(w.Inbox, test.Name+".png")
+		if test.Data != nil {
+			if err := ioutil.WriteFile(path, test.Data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			writeTestPNG(t, path, test.Width, test.Height)
+		}
+		w.Process(path)
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: file was not removed from the inbox", test.Name)
+		}
+		for _, dir := range []string{DoneDir, ReviewDir, FailedDir} {
+			outPath := filepath.Join(w.OutDir, dir, test.Name+".png")
+			_, err := os.Stat(outPath)
+			if exists := err == nil; exists != (dir == test.Dir) {
+				t.Errorf("%s: unexpected existence in %s: %v", test.Name, dir, exists)
+			}
+		}
+		if test.OutWidth != 0 {
+			outPath := filepath.Join(w.OutDir, test.Dir, test.Name+".png")
+			if img, _, err := autorot.ReadImage(outPath); err != nil {
+				t.Errorf("%s: %v", test.Name, err)
+			} else if size := img.Bounds().Size(); size.X != test.OutWidth ||
+				size.Y != test.OutHeight {
+				t.Errorf("%s: expected %dx%d output but got %dx%d", test.Name,
+					test.OutWidth, test.OutHeight, size.X, size.Y)
+			}
+		}
+		os.RemoveAll(w.OutDir)
+	}
+}
+
+func testWatcher(t *testing.T, mode string) *Watcher {
+	dir, err := ioutil.TempDir("", "autorot-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Watcher{
+		Model:     fakeEvaluator{},
+		Inbox:     filepath.Join(dir, "inbox"),
+		OutDir:    dir,
+		Threshold: 0.9,
+		Mode:      mode,
+		Quality:   90,
+	}
+	for _, sub := range []string{"inbox", DoneDir, ReviewDir, FailedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
+
+func writeTestPNG(t *testing.T, path string, width, height int) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFiles(t *testing.T, dir string, names ...string) {
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, info := range listing {
+		actual = append(actual, info.Name())
+	}
+	if len(actual) != len(names) {
+		t.Errorf("%s: expected %v but got %v", dir, names, actual)
+		return
+	}
+	for i, name := range names {
+		if actual[i] != name {
+			t.Errorf("%s: expected %v but got %v", dir, names, actual)
+			return
+		}
+	}
+}